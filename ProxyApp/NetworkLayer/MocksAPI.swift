@@ -24,6 +24,7 @@ protocol MocksAPI {
     
     
     @POST("/api/apps")
+    @Headers(["Content-Type": "application/json"])
     func setApps(_ apps: Body<AuthorizedApp>) async throws
     
 }
@@ -16,6 +16,8 @@ class ProxyCore: ObservableObject {
     private var cancellables = Set<AnyCancellable>()
     var onNewLog: (ProxyLog) -> Void = { _ in }
     @Published var logs: [ProxyLog] = []
+    // Incrementato a ogni "mocks_changed", per ricaricare le regole
+    @Published var mocksRevision: Int = 0
     // Path di installazione finale del binario
     private var execURL: URL {
         FileManager.default
@@ -137,12 +139,32 @@ class ProxyCore: ObservableObject {
         }
     }
     
+    // Messaggi della /ws: {"type": ..., "data": ...}
+    private struct ProxyEvent: Decodable {
+        let type: String
+    }
+
+    private struct CompletedLogEvent: Decodable {
+        let data: ProxyLog
+    }
+
     private func handleWebSocketData(_ data: Data) {
         do {
-            let log = try JSONDecoder().decode(ProxyLog.self, from: data)
-            DispatchQueue.main.async {
-                self.logs.append(log)
-                self.onNewLog(log)
+            let event = try JSONDecoder().decode(ProxyEvent.self, from: data)
+            switch event.type {
+            case "log_completed":
+                let log = try JSONDecoder().decode(CompletedLogEvent.self, from: data).data
+                DispatchQueue.main.async {
+                    self.logs.append(log)
+                    self.onNewLog(log)
+                }
+            case "mocks_changed":
+                DispatchQueue.main.async {
+                    self.mocksRevision += 1
+                }
+            default:
+                // Gli altri eventi (app, passthrough, CA...) non sono ancora usati
+                break
             }
         } catch {
             print("❌ Errore parsing log:", error)
@@ -17,6 +17,7 @@ struct MockManagerView: View {
     @EnvironmentObject var modalRouter: ModalRouter
     @EnvironmentObject var provider: MainProvider
     @EnvironmentObject var editMock: MockModalEditViewModel
+    @EnvironmentObject var proxyCore: ProxyCore
     init(viewModel: MockManagerViewModel) {
         self._viewModel = StateObject(wrappedValue: viewModel)
     }
@@ -100,6 +101,11 @@ struct MockManagerView: View {
                 await viewModel.fetchMocks()
             }
         }
+        .onChange(of: proxyCore.mocksRevision) { _ in
+            Task {
+                await viewModel.fetchMocks()
+            }
+        }
         .alert("Delete Mock", isPresented: Binding(get: {
             showDeleteAlert != nil
         }, set: { if !$0 { showDeleteAlert = nil } }), presenting: showDeleteAlert) { mock in
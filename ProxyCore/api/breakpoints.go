@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"proxy_core/proxy"
+	"strings"
+)
+
+// handleBreakpoints serves GET /api/breakpoints: the exchanges waiting at a
+// breakpoint.
+func (s *APIServer) handleBreakpoints(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.proxyServer.PausedExchanges())
+}
+
+// handleBreakpointByID serves /api/breakpoints/{id}: GET shows the paused
+// exchange, POST releases it with a proxy.BreakpointDecision
+// ({"action": "continue"|"abort", "message": {...}}). An empty body continues.
+func (s *APIServer) handleBreakpointByID(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/breakpoints/")
+	if id == "" {
+		http.Error(w, "Missing ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		exchange, ok := s.proxyServer.GetPausedExchange(id)
+		if !ok {
+			http.Error(w, proxy.ErrBreakpointNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(exchange)
+	case http.MethodPost:
+		if !requireJSON(w, r) {
+			return
+		}
+		var decision proxy.BreakpointDecision
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&decision); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+		}
+		if err := s.proxyServer.ReleaseBreakpoint(id, decision); err != nil {
+			status := http.StatusBadRequest
+			if err == proxy.ErrBreakpointNotFound {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *APIServer) handleBreakpointRules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.breakpoints.ListRules())
+	case http.MethodPost:
+		if !requireJSON(w, r) {
+			return
+		}
+		var rule proxy.BreakpointRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		saved, err := s.breakpoints.AddRule(rule)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(saved)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *APIServer) handleBreakpointRuleByID(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/breakpoints/rules/")
+	if id == "" {
+		http.Error(w, "Missing ID", http.StatusBadRequest)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.breakpoints.DeleteRule(id); err != nil {
+		http.Error(w, "Breakpoint not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
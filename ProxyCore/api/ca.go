@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"proxy_core/cert"
+)
+
+func (s *APIServer) handleCA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.proxyServer.CertManager().CAInfo())
+}
+
+func (s *APIServer) handleCARegenerate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Even without a body, so that a plain form post cannot trigger it
+	if !requireJSON(w, r) {
+		return
+	}
+
+	var opts cert.CAOptions
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+	}
+
+	certManager := s.proxyServer.CertManager()
+	if err := certManager.RegenerateCA(opts); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(certManager.CAInfo())
+}
+
+// handleCADownload serves the CA as ?format=pem (default, with chain), der or
+// mobileconfig (iOS configuration profile).
+func (s *APIServer) handleCADownload(w http.ResponseWriter, r *http.Request) {
+	certManager := s.proxyServer.CertManager()
+
+	switch r.URL.Query().Get("format") {
+	case "", "pem":
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Header().Set("Content-Disposition", "attachment; filename=proxy-ca.pem")
+		w.Write(certManager.CAChainPEM())
+	case "der", "cer":
+		w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+		w.Header().Set("Content-Disposition", "attachment; filename=proxy-ca.cer")
+		w.Write(certManager.CADER())
+	case "mobileconfig":
+		profile, err := certManager.MobileConfig()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-apple-aspen-config")
+		w.Header().Set("Content-Disposition", "attachment; filename=proxy-ca.mobileconfig")
+		w.Write(profile)
+	default:
+		http.Error(w, "Unknown format", http.StatusBadRequest)
+	}
+}
+
+// caImportRequest carries either a PEM certificate (optionally followed by its
+// chain) with its key, or a base64 encoded PKCS#12 bundle.
+type caImportRequest struct {
+	CertPEM  string `json:"cert_pem,omitempty"`
+	KeyPEM   string `json:"key_pem,omitempty"`
+	ChainPEM string `json:"chain_pem,omitempty"`
+	PKCS12   []byte `json:"pkcs12,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+func (s *APIServer) handleCAImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireJSON(w, r) {
+		return
+	}
+
+	var req caImportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	certManager := s.proxyServer.CertManager()
+	var err error
+	switch {
+	case len(req.PKCS12) > 0:
+		err = certManager.ImportCAPKCS12(req.PKCS12, req.Password)
+	case req.CertPEM != "":
+		err = certManager.ImportCA([]byte(req.CertPEM+"\n"+req.ChainPEM), []byte(req.KeyPEM))
+	default:
+		http.Error(w, "cert_pem or pkcs12 required", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"proxy_core/proxy"
+	"strings"
+)
+
+// handleCompose serves POST /api/compose: the proxy.ComposeRequest in the body
+// is sent upstream and the resulting log entry is returned.
+func (s *APIServer) handleCompose(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireJSON(w, r) {
+		return
+	}
+
+	var compose proxy.ComposeRequest
+	if err := json.NewDecoder(r.Body).Decode(&compose); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	s.sendComposed(w, r, compose)
+}
+
+func (s *APIServer) sendComposed(w http.ResponseWriter, r *http.Request, compose proxy.ComposeRequest) {
+	entry, err := s.proxyServer.Compose(r.Context(), compose)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+func (s *APIServer) handleCollections(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.collections.ListCollections())
+	case http.MethodPost:
+		if !requireJSON(w, r) {
+			return
+		}
+		var collection proxy.Collection
+		if err := json.NewDecoder(r.Body).Decode(&collection); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		s.saveCollection(w, collection)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleCollectionByID serves /api/collections/{id} (GET, PUT, DELETE) and
+// POST /api/collections/{id}/requests/{requestID}/send.
+func (s *APIServer) handleCollectionByID(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/collections/")
+	id, rest, _ := strings.Cut(rest, "/")
+	if id == "" {
+		http.Error(w, "Missing ID", http.StatusBadRequest)
+		return
+	}
+
+	if rest != "" {
+		requestID, action, _ := strings.Cut(strings.TrimPrefix(rest, "requests/"), "/")
+		if !strings.HasPrefix(rest, "requests/") || action != "send" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !requireJSON(w, r) {
+			return
+		}
+		collection, ok := s.collections.GetCollection(id)
+		if !ok {
+			http.Error(w, "Collection not found", http.StatusNotFound)
+			return
+		}
+		for _, saved := range collection.Requests {
+			if saved.ID == requestID {
+				s.sendComposed(w, r, saved.ComposeRequest)
+				return
+			}
+		}
+		http.Error(w, "Request not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		collection, ok := s.collections.GetCollection(id)
+		if !ok {
+			http.Error(w, "Collection not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(collection)
+	case http.MethodPut:
+		var collection proxy.Collection
+		if err := json.NewDecoder(r.Body).Decode(&collection); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		collection.ID = id
+		s.saveCollection(w, collection)
+	case http.MethodDelete:
+		if err := s.collections.DeleteCollection(id); err != nil {
+			http.Error(w, "Collection not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *APIServer) saveCollection(w http.ResponseWriter, collection proxy.Collection) {
+	saved, err := s.collections.SaveCollection(collection)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, proxy.ErrInvalid) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(saved)
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"proxy_core/proxy"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxHARSize bounds the files accepted by /api/import/har.
+const maxHARSize = 512 << 20
+
+// handleExportHAR serves /api/export/har. Entries come from the current logs
+// or from ?session=<id>, narrowed by ?ids=1,2,3 or by the /api/logs filters.
+func (s *APIServer) handleExportHAR(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	sessionID := query.Get("session")
+	store := s.proxyServer.Store()
+	if sessionID != "" && store == nil {
+		http.Error(w, "Session storage is disabled", http.StatusNotImplemented)
+		return
+	}
+
+	var logs []proxy.RequestLog
+	if ids := query.Get("ids"); ids != "" {
+		for _, part := range strings.Split(ids, ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(part), 10, 64)
+			if err != nil {
+				http.Error(w, "Invalid log ID "+part, http.StatusBadRequest)
+				return
+			}
+			var entry proxy.RequestLog
+			if sessionID != "" {
+				entry, err = store.GetLog(sessionID, id)
+			} else {
+				entry, err = s.currentLog(id)
+			}
+			if err != nil {
+				sessionError(w, err)
+				return
+			}
+			logs = append(logs, entry)
+		}
+	} else {
+		filter, err := proxy.ParseLogFilter(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logQuery := proxy.LogQuery{Filter: filter, Ascending: true, Limit: 1000}
+		if sessionID == "" {
+			// The in-memory log never holds more than one page
+			logs, _ = s.proxyServer.QueryLogs(logQuery)
+		} else {
+			for {
+				page, next, err := store.QueryLogs(sessionID, logQuery)
+				if err != nil {
+					sessionError(w, err)
+					return
+				}
+				logs = append(logs, page...)
+				if next == 0 {
+					break
+				}
+				logQuery.Cursor = next
+			}
+		}
+	}
+
+	filename := fmt.Sprintf("packetpeek-%s.har", time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	json.NewEncoder(w).Encode(proxy.ToHAR(logs))
+}
+
+// handleImportHAR serves /api/import/har: the HAR in the body is stored as a
+// new session, named after ?name= when given. Recording is not switched to it.
+func (s *APIServer) handleImportHAR(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireJSON(w, r) {
+		return
+	}
+	store := s.proxyServer.Store()
+	if store == nil {
+		http.Error(w, "Session storage is disabled", http.StatusNotImplemented)
+		return
+	}
+
+	var har proxy.HAR
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxHARSize)).Decode(&har); err != nil {
+		http.Error(w, "Invalid HAR: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	logs, err := proxy.FromHAR(har)
+	if err != nil {
+		http.Error(w, "Invalid HAR: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = "Import " + time.Now().Format("2006-01-02 15:04:05")
+	}
+	session, err := store.CreateSession(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := store.SaveLogs(session.ID, logs); err != nil {
+		store.DeleteSession(session.ID)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if session, err = store.GetSession(session.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sessionInfo{Session: session})
+}
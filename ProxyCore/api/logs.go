@@ -0,0 +1,202 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"proxy_core/proxy"
+	"strconv"
+	"strings"
+)
+
+type logsPage struct {
+	Logs []proxy.RequestLog `json:"logs"`
+	// NextCursor is passed back as ?cursor= for the following page, empty on
+	// the last one
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// handleLogs serves /api/logs: the filters of proxy.ParseLogFilter plus
+// cursor, limit (default 100, max 1000) and order (desc by default, or asc).
+func (s *APIServer) handleLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	logQuery, err := parseLogQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logs, next := s.proxyServer.QueryLogs(logQuery)
+	writeLogsPage(w, logs, next)
+}
+
+// parseLogQuery reads the filters and paging parameters shared by the log
+// listing endpoints.
+func parseLogQuery(query url.Values) (proxy.LogQuery, error) {
+	filter, err := proxy.ParseLogFilter(query)
+	if err != nil {
+		return proxy.LogQuery{}, err
+	}
+	logQuery := proxy.LogQuery{Filter: filter}
+	if cursor := query.Get("cursor"); cursor != "" {
+		if logQuery.Cursor, err = strconv.ParseUint(cursor, 10, 64); err != nil {
+			return logQuery, fmt.Errorf("invalid cursor")
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if logQuery.Limit, err = strconv.Atoi(limit); err != nil {
+			return logQuery, fmt.Errorf("invalid limit")
+		}
+	}
+	switch query.Get("order") {
+	case "", "desc":
+	case "asc":
+		logQuery.Ascending = true
+	default:
+		return logQuery, fmt.Errorf("order must be asc or desc")
+	}
+	return logQuery, nil
+}
+
+func writeLogsPage(w http.ResponseWriter, logs []proxy.RequestLog, next uint64) {
+	page := logsPage{Logs: logs}
+	if next != 0 {
+		page.NextCursor = strconv.FormatUint(next, 10)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// handleLogByID serves /api/logs/{id} and its /body, /snippet and /replay
+// subresources.
+func (s *APIServer) handleLogByID(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/logs/")
+	idPart, action, _ := strings.Cut(rest, "/")
+	id, err := strconv.ParseUint(idPart, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid log ID", http.StatusBadRequest)
+		return
+	}
+
+	entry, err := s.currentLog(id)
+	if err != nil {
+		sessionError(w, err)
+		return
+	}
+
+	switch action {
+	case "":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entry)
+	case "body":
+		s.handleLogBody(w, r, entry)
+	case "snippet":
+		s.handleLogSnippet(w, r, entry)
+	case "replay":
+		s.handleLogReplay(w, r, entry, "")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleLogBody serves the raw body of a log entry with its original content
+// type: ?part=response (default) or request. With ?download=1 it is sent as
+// an attachment. Content-Encoding is already removed.
+func (s *APIServer) handleLogBody(w http.ResponseWriter, r *http.Request, entry proxy.RequestLog) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body []byte
+	var contentType, mimeType string
+	switch r.URL.Query().Get("part") {
+	case "", "response":
+		body = entry.RawResponseBody
+		contentType = entry.ResponseHeaders["Content-Type"]
+		mimeType = entry.ResponseMIMEType
+	case "request":
+		body = entry.RawRequestBody
+		contentType = entry.RequestHeaders["Content-Type"]
+		mimeType = entry.RequestMIMEType
+	default:
+		http.Error(w, "part must be request or response", http.StatusBadRequest)
+		return
+	}
+
+	if contentType == "" {
+		contentType = mimeType
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	// Captured bodies come from anywhere: opened in a browser, HTML and SVG
+	// must not run scripts on the API origin
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+
+	if r.URL.Query().Get("download") == "1" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", bodyFilename(entry)))
+	}
+	w.Write(body)
+}
+
+// handleLogSnippet renders the request of a log entry as code:
+// ?format=curl (default), httpie, go, swift or fetch. ?strip_auth=1 leaves
+// out credentials such as Authorization and Cookie.
+func (s *APIServer) handleLogSnippet(w http.ResponseWriter, r *http.Request, entry proxy.RequestLog) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = proxy.SnippetCurl
+	}
+	stripAuth, _ := strconv.ParseBool(query.Get("strip_auth"))
+
+	snippet, err := proxy.RenderSnippet(entry, format, proxy.SnippetOptions{StripAuth: stripAuth})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(snippet))
+}
+
+// bodyFilename is the last segment of the request path, or "body".
+func bodyFilename(entry proxy.RequestLog) string {
+	if u, err := url.Parse(entry.URL); err == nil {
+		if name := path.Base(u.Path); name != "." && name != "/" {
+			return name
+		}
+	}
+	return "body"
+}
+
+// currentLog returns an entry of the session being recorded. Entries trimmed
+// from memory are read back from the store.
+func (s *APIServer) currentLog(id uint64) (proxy.RequestLog, error) {
+	if entry, ok := s.proxyServer.GetLog(id); ok {
+		return entry, nil
+	}
+	store := s.proxyServer.Store()
+	if store == nil {
+		return proxy.RequestLog{}, proxy.ErrLogNotFound
+	}
+	return store.GetLog(s.proxyServer.CurrentSession(), id)
+}
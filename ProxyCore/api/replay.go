@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"proxy_core/proxy"
+	"time"
+)
+
+type replayResult struct {
+	ID         uint64  `json:"id"`
+	StatusCode int     `json:"status_code"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+type replaySummary struct {
+	Count       int         `json:"count"`
+	StatusCodes map[int]int `json:"status_codes"`
+	MinMs       float64     `json:"min_ms"`
+	AvgMs       float64     `json:"avg_ms"`
+	MaxMs       float64     `json:"max_ms"`
+}
+
+type replayResponse struct {
+	Logs    []replayResult `json:"logs"`
+	Summary replaySummary  `json:"summary"`
+}
+
+// handleLogReplay serves POST /api/logs/{id}/replay. The body is an optional
+// proxy.ReplayRequest; the response lists the new log entries with timing
+// stats, the entries themselves are available through /api/logs.
+func (s *APIServer) handleLogReplay(w http.ResponseWriter, r *http.Request, entry proxy.RequestLog, sessionID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireJSON(w, r) {
+		return
+	}
+
+	var replay proxy.ReplayRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&replay); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+	}
+
+	logs, err := s.proxyServer.Replay(r.Context(), entry, sessionID, replay)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := replayResponse{
+		Logs:    make([]replayResult, 0, len(logs)),
+		Summary: replaySummary{Count: len(logs), StatusCodes: make(map[int]int)},
+	}
+	var total float64
+	for i, log := range logs {
+		ms := float64(log.ResponseTime) / float64(time.Millisecond)
+		resp.Logs = append(resp.Logs, replayResult{ID: log.ID, StatusCode: log.StatusCode, DurationMs: ms})
+		resp.Summary.StatusCodes[log.StatusCode]++
+		total += ms
+		if i == 0 || ms < resp.Summary.MinMs {
+			resp.Summary.MinMs = ms
+		}
+		resp.Summary.MaxMs = max(resp.Summary.MaxMs, ms)
+	}
+	if len(logs) > 0 {
+		resp.Summary.AvgMs = total / float64(len(logs))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
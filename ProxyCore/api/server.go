@@ -2,7 +2,9 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
+	"mime"
 	"net/http"
 	"proxy_core/proxy"
 	"strings"
@@ -18,13 +20,17 @@ type APIServer struct {
 	clients     map[*websocket.Conn]struct{}
 	mu          sync.RWMutex
 	mockManager *proxy.MockManager
+	collections *proxy.CollectionManager
+	breakpoints *proxy.BreakpointManager
 }
 
 func NewAPIServer(proxyServer *proxy.ProxyServer) *APIServer {
 	return &APIServer{
 		proxyServer: proxyServer,
-		appsManager: proxy.NewMonitoredAppsManager("monitored_apps.json"),
-		mockManager: proxy.NewMockManager("mocks.json"),
+		appsManager: proxyServer.AppsManager(),
+		mockManager: proxyServer.MockManager(),
+		collections: proxyServer.CollectionManager(),
+		breakpoints: proxyServer.BreakpointManager(),
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true // Allow all origins for development
@@ -36,7 +42,15 @@ func NewAPIServer(proxyServer *proxy.ProxyServer) *APIServer {
 
 func (s *APIServer) Start(addr string) error {
 	http.HandleFunc("/logs", s.handleGetLogs)
+	http.HandleFunc("/api/logs", s.handleLogs)
+	http.HandleFunc("/api/logs/", s.handleLogByID)
+	http.HandleFunc("/api/sessions", s.handleSessions)
+	http.HandleFunc("/api/sessions/", s.handleSessionByID)
+	http.HandleFunc("/api/export/har", s.handleExportHAR)
+	http.HandleFunc("/api/import/har", s.handleImportHAR)
 	http.HandleFunc("/ws", s.handleWebSocket)
+	http.HandleFunc("/ws/frames", s.handleFramesWebSocket)
+	http.HandleFunc("/api/frames", s.handleGetFrames)
 	http.HandleFunc("/welcome", s.handleWelcome)
 	http.HandleFunc("/cert/ios", s.handleIOSCert)
 	http.HandleFunc("/cert/macos", s.handleMacOSCert)
@@ -44,6 +58,19 @@ func (s *APIServer) Start(addr string) error {
 	http.HandleFunc("/api/apps/", s.handleAppOperation)
 	http.HandleFunc("/api/mocks", s.handleMocks)     // GET e POST
 	http.HandleFunc("/api/mocks/", s.handleMockByID) // attenzione allo slash finale!
+	http.HandleFunc("/api/compose", s.handleCompose)
+	http.HandleFunc("/api/collections", s.handleCollections)
+	http.HandleFunc("/api/collections/", s.handleCollectionByID)
+	http.HandleFunc("/api/breakpoints", s.handleBreakpoints)
+	http.HandleFunc("/api/breakpoints/", s.handleBreakpointByID)
+	http.HandleFunc("/api/breakpoints/rules", s.handleBreakpointRules)
+	http.HandleFunc("/api/breakpoints/rules/", s.handleBreakpointRuleByID)
+	http.HandleFunc("/api/passthrough", s.handlePassThrough)
+	http.HandleFunc("/api/passthrough/", s.handlePassThroughHost)
+	http.HandleFunc("/api/ca", s.handleCA)
+	http.HandleFunc("/api/ca/import", s.handleCAImport)
+	http.HandleFunc("/api/ca/regenerate", s.handleCARegenerate)
+	http.HandleFunc("/api/ca/download", s.handleCADownload)
 
 	s.serveStaticFiles()
 	return http.ListenAndServe(addr, nil)
@@ -75,17 +102,23 @@ func (s *APIServer) handleMocks(w http.ResponseWriter, r *http.Request) {
 		mocks := s.mockManager.ListMocks()
 		json.NewEncoder(w).Encode(mocks)
 	case http.MethodPost:
+		if !requireJSON(w, r) {
+			return
+		}
 		var mock proxy.MockResponse
 		if err := json.NewDecoder(r.Body).Decode(&mock); err != nil {
 			http.Error(w, "Invalid JSON", http.StatusBadRequest)
 			return
 		}
-		error := s.mockManager.AddMock(mock)
-		if error == nil {
-			w.WriteHeader(http.StatusOK)
+		if err := s.mockManager.AddMock(mock); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, proxy.ErrInvalid) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
 			return
 		}
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusOK)
 
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -97,7 +130,15 @@ func (s *APIServer) handleGetLogs(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(s.proxyServer.GetLogs())
 }
 
+// handleWebSocket streams events. The query accepts the same filters as
+// /api/logs, applied to log events only.
 func (s *APIServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	filter, err := proxy.ParseLogFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("Failed to upgrade connection: %v", err)
@@ -105,25 +146,53 @@ func (s *APIServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
-	// Subscribe to proxy logs
-	logChan := s.proxyServer.Subscribe()
+	// Subscribe to proxy logs and rule change events
+	eventChan := s.proxyServer.SubscribeEvents()
 	defer func() {
-		s.proxyServer.Unsubscribe(logChan)
+		s.proxyServer.UnsubscribeEvents(eventChan)
 		s.mu.Lock()
 		delete(s.clients, conn)
 		s.mu.Unlock()
 		conn.Close()
 	}()
 
-	// Send logs to client
-	for logMessage := range logChan {
-		err := conn.WriteJSON(logMessage)
-		if err != nil {
+	// Send logs and events to client
+	for event := range eventChan {
+		if entry, ok := event.Data.(proxy.RequestLog); ok && !filter.Match(entry) {
+			continue
+		}
+		if err := conn.WriteJSON(event); err != nil {
 			log.Println(err)
 			return
 		}
 	}
 }
+
+func (s *APIServer) handleGetFrames(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.proxyServer.GetFrames(r.URL.Query().Get("connection_id")))
+}
+
+// handleFramesWebSocket streams the frames of intercepted WebSocket connections
+func (s *APIServer) handleFramesWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	frameChan := s.proxyServer.SubscribeFrames()
+	defer s.proxyServer.UnsubscribeFrames(frameChan)
+
+	for frame := range frameChan {
+		if err := conn.WriteJSON(frame); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+}
+
 func (s *APIServer) serveStaticFiles() {
 	// Servi i file statici dalla cartella corrente
 	fs := http.FileServer(http.Dir("."))
@@ -248,13 +317,13 @@ func (s *APIServer) handleWelcome(w http.ResponseWriter, r *http.Request) {
 func (s *APIServer) handleIOSCert(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/x-x509-ca-cert")
 	w.Header().Set("Content-Disposition", "attachment; filename=proxy-ca.pem")
-	http.ServeFile(w, r, "ca.pem")
+	w.Write(s.proxyServer.CertManager().CAChainPEM())
 }
 
 func (s *APIServer) handleMacOSCert(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/x-x509-ca-cert")
 	w.Header().Set("Content-Disposition", "attachment; filename=proxy-ca.pem")
-	http.ServeFile(w, r, "ca.pem")
+	w.Write(s.proxyServer.CertManager().CAChainPEM())
 }
 
 func (s *APIServer) handleApps(w http.ResponseWriter, r *http.Request) {
@@ -265,6 +334,9 @@ func (s *APIServer) handleApps(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(apps)
 
 	case http.MethodPost:
+		if !requireJSON(w, r) {
+			return
+		}
 		// Aggiungi una nuova app
 		var app proxy.MonitoredApp
 		if err := json.NewDecoder(r.Body).Decode(&app); err != nil {
@@ -311,3 +383,66 @@ func (s *APIServer) handleAppOperation(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
+
+func (s *APIServer) handlePassThrough(w http.ResponseWriter, r *http.Request) {
+	passThrough := s.proxyServer.PassThroughList()
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(passThrough.List())
+
+	case http.MethodPost:
+		if !requireJSON(w, r) {
+			return
+		}
+		var host proxy.PassThroughHost
+		if err := json.NewDecoder(r.Body).Decode(&host); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if host.Host == "" {
+			http.Error(w, "Host required", http.StatusBadRequest)
+			return
+		}
+		if err := passThrough.Add(host.Host, host.Reason); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *APIServer) handlePassThroughHost(w http.ResponseWriter, r *http.Request) {
+	host := strings.TrimPrefix(r.URL.Path, "/api/passthrough/")
+	if host == "" {
+		http.Error(w, "Host required", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodDelete:
+		if err := s.proxyServer.PassThroughList().Remove(host); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// requireJSON answers 415 unless the request declares a JSON body. Web pages
+// can only send other content types cross-origin without a preflight, so this
+// keeps them from changing the proxy state through the user's browser.
+func requireJSON(w http.ResponseWriter, r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return false
+	}
+	return true
+}
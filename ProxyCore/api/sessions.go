@@ -0,0 +1,196 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"proxy_core/proxy"
+	"strconv"
+	"strings"
+)
+
+type sessionInfo struct {
+	proxy.Session
+	// Active marks the session new traffic is recorded in
+	Active bool `json:"active"`
+}
+
+type sessionRequest struct {
+	Name string `json:"name"`
+}
+
+// handleSessions serves /api/sessions: GET lists the stored captures, POST
+// starts recording into a new one.
+func (s *APIServer) handleSessions(w http.ResponseWriter, r *http.Request) {
+	store := s.proxyServer.Store()
+	if store == nil {
+		http.Error(w, "Session storage is disabled", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sessions, err := store.ListSessions()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		current := s.proxyServer.CurrentSession()
+		infos := make([]sessionInfo, 0, len(sessions))
+		for _, session := range sessions {
+			infos = append(infos, sessionInfo{Session: session, Active: session.ID == current})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(infos)
+	case http.MethodPost:
+		if !requireJSON(w, r) {
+			return
+		}
+		var req sessionRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+		}
+		session, err := s.proxyServer.StartSession(req.Name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(sessionInfo{Session: session, Active: true})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSessionByID serves /api/sessions/{id} (GET, PATCH to rename, DELETE),
+// /api/sessions/{id}/logs with the same parameters as /api/logs, and
+// /api/sessions/{id}/logs/{logID}[/body|/snippet|/replay].
+func (s *APIServer) handleSessionByID(w http.ResponseWriter, r *http.Request) {
+	store := s.proxyServer.Store()
+	if store == nil {
+		http.Error(w, "Session storage is disabled", http.StatusNotImplemented)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, "/api/sessions/")
+	id, rest, _ := strings.Cut(rest, "/")
+	if id == "" {
+		http.Error(w, "Missing ID", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case rest == "":
+		s.handleSession(w, r, store, id)
+	case rest == "logs":
+		s.handleSessionLogs(w, r, store, id)
+	case strings.HasPrefix(rest, "logs/"):
+		s.handleSessionLog(w, r, store, id, strings.TrimPrefix(rest, "logs/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *APIServer) handleSession(w http.ResponseWriter, r *http.Request, store proxy.LogStore, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		session, err := store.GetSession(id)
+		if err != nil {
+			sessionError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sessionInfo{Session: session, Active: id == s.proxyServer.CurrentSession()})
+	case http.MethodPatch:
+		var req sessionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+			http.Error(w, "Missing name", http.StatusBadRequest)
+			return
+		}
+		if err := store.RenameSession(id, strings.TrimSpace(req.Name)); err != nil {
+			sessionError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		if id == s.proxyServer.CurrentSession() {
+			http.Error(w, "Cannot delete the session being recorded", http.StatusConflict)
+			return
+		}
+		if err := store.DeleteSession(id); err != nil {
+			sessionError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *APIServer) handleSessionLogs(w http.ResponseWriter, r *http.Request, store proxy.LogStore, id string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	logQuery, err := parseLogQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logs, next, err := store.QueryLogs(id, logQuery)
+	if err != nil {
+		sessionError(w, err)
+		return
+	}
+	writeLogsPage(w, logs, next)
+}
+
+func (s *APIServer) handleSessionLog(w http.ResponseWriter, r *http.Request, store proxy.LogStore, sessionID, rest string) {
+	idPart, action, _ := strings.Cut(rest, "/")
+	id, err := strconv.ParseUint(idPart, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid log ID", http.StatusBadRequest)
+		return
+	}
+
+	entry, err := store.GetLog(sessionID, id)
+	if err != nil {
+		sessionError(w, err)
+		return
+	}
+
+	switch action {
+	case "":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entry)
+	case "body":
+		s.handleLogBody(w, r, entry)
+	case "snippet":
+		s.handleLogSnippet(w, r, entry)
+	case "replay":
+		// Replays are recorded in the current session
+		if sessionID == s.proxyServer.CurrentSession() {
+			sessionID = ""
+		}
+		s.handleLogReplay(w, r, entry, sessionID)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func sessionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, proxy.ErrSessionNotFound) || errors.Is(err, proxy.ErrLogNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
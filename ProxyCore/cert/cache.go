@@ -0,0 +1,168 @@
+package cert
+
+import (
+	"container/list"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLeafCacheSize = 1000
+	// Leafs are regenerated this long before they actually expire
+	leafRenewBefore = 24 * time.Hour
+)
+
+// leafCache is a concurrency-safe LRU of issued leaf certificates keyed by
+// hostname. Concurrent requests for the same missing host share one generation.
+type leafCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+	inflight map[string]*leafCall
+	// epoch is bumped by purge, so leafs created meanwhile are not stored
+	epoch uint64
+}
+
+type leafEntry struct {
+	host string
+	cert *tls.Certificate
+}
+
+type leafCall struct {
+	done chan struct{}
+	cert *tls.Certificate
+	err  error
+	// stale is set when the cache was purged while creating the leaf
+	stale bool
+}
+
+func newLeafCache(capacity int) *leafCache {
+	if capacity <= 0 {
+		capacity = defaultLeafCacheSize
+	}
+	return &leafCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		inflight: make(map[string]*leafCall),
+	}
+}
+
+// getOrCreate returns the cached certificate for host, calling create at most
+// once per host when it is missing or about to expire.
+func (c *leafCache) getOrCreate(host string, create func() (*tls.Certificate, error)) (*tls.Certificate, error) {
+	c.mu.Lock()
+	if elem, ok := c.entries[host]; ok {
+		entry := elem.Value.(*leafEntry)
+		if leafIsFresh(entry.cert) {
+			c.order.MoveToFront(elem)
+			c.mu.Unlock()
+			return entry.cert, nil
+		}
+		c.order.Remove(elem)
+		delete(c.entries, host)
+	}
+	if call, ok := c.inflight[host]; ok {
+		c.mu.Unlock()
+		<-call.done
+		if call.stale {
+			return c.getOrCreate(host, create)
+		}
+		return call.cert, call.err
+	}
+	call := &leafCall{done: make(chan struct{})}
+	c.inflight[host] = call
+	epoch := c.epoch
+	c.mu.Unlock()
+
+	call.cert, call.err = create()
+
+	c.mu.Lock()
+	if c.inflight[host] == call {
+		delete(c.inflight, host)
+	}
+	// A leaf created before a purge may be signed by the previous CA
+	call.stale = epoch != c.epoch
+	if call.err == nil && !call.stale {
+		c.entries[host] = c.order.PushFront(&leafEntry{host: host, cert: call.cert})
+		for c.order.Len() > c.capacity {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*leafEntry).host)
+		}
+	}
+	c.mu.Unlock()
+	close(call.done)
+
+	if call.stale {
+		return c.getOrCreate(host, create)
+	}
+	return call.cert, call.err
+}
+
+// purge drops every cached leaf, e.g. after the CA changed. Leafs being
+// created at the same time are thrown away and created again.
+func (c *leafCache) purge() {
+	c.mu.Lock()
+	c.epoch++
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.inflight = make(map[string]*leafCall)
+	c.mu.Unlock()
+}
+
+func leafIsFresh(cert *tls.Certificate) bool {
+	return cert.Leaf != nil && time.Now().Add(leafRenewBefore).Before(cert.Leaf.NotAfter)
+}
+
+// leafCachePath maps a cache key to a file name that is safe on every platform.
+func (cm *CertManager) leafCachePath(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, key)
+	return filepath.Join(cm.config.LeafCacheDir, name+".pem")
+}
+
+// loadLeafFromDisk returns a previously persisted leaf if it is still fresh and
+// was issued by the current CA.
+func (cm *CertManager) loadLeafFromDisk(key string) (*tls.Certificate, bool) {
+	if cm.config.LeafCacheDir == "" {
+		return nil, false
+	}
+	data, err := os.ReadFile(cm.leafCachePath(key))
+	if err != nil {
+		return nil, false
+	}
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil || !leafIsFresh(&cert) {
+		return nil, false
+	}
+	cm.mu.RLock()
+	caCert := cm.CACert
+	cm.mu.RUnlock()
+	if err := cert.Leaf.CheckSignatureFrom(caCert); err != nil {
+		return nil, false
+	}
+	cert.Certificate = append(cert.Certificate[:1], cm.leafChain()...)
+	return &cert, true
+}
+
+func (cm *CertManager) saveLeafToDisk(key string, certPEM, keyPEM []byte) error {
+	if cm.config.LeafCacheDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(cm.config.LeafCacheDir, 0700); err != nil {
+		return err
+	}
+	data := append(append([]byte{}, certPEM...), keyPEM...)
+	return os.WriteFile(cm.leafCachePath(key), data, 0600)
+}
@@ -0,0 +1,266 @@
+package cert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// ImportCA replaces the current CA with a user supplied one, e.g. a team or
+// corporate CA, so every machine issues leafs trusted by the same root.
+// certPEM may contain the CA certificate followed by its intermediate chain and
+// keyPEM may be empty when the key is bundled in certPEM. The imported CA is
+// persisted and replaces ca.pem/ca.key.
+func (cm *CertManager) ImportCA(certPEM, keyPEM []byte) error {
+	caCert, key, chain, err := parseCAPEM(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	return cm.installCA(caCert, key, chain, true)
+}
+
+// ImportCAPKCS12 is like ImportCA for a PKCS#12 (.p12/.pfx) bundle.
+func (cm *CertManager) ImportCAPKCS12(data []byte, password string) error {
+	caCert, key, chain, err := parseCAPKCS12(data, password)
+	if err != nil {
+		return err
+	}
+	return cm.installCA(caCert, key, chain, true)
+}
+
+// loadConfiguredCA loads the CA files given in Config without persisting them,
+// so the user keeps ownership of the originals.
+func (cm *CertManager) loadConfiguredCA() error {
+	if cm.config.CAPKCS12File != "" {
+		data, err := os.ReadFile(cm.config.CAPKCS12File)
+		if err != nil {
+			return err
+		}
+		caCert, key, chain, err := parseCAPKCS12(data, cm.config.CAPKCS12Password)
+		if err != nil {
+			return err
+		}
+		return cm.installCA(caCert, key, chain, false)
+	}
+
+	certPEM, err := os.ReadFile(cm.config.CACertFile)
+	if err != nil {
+		return err
+	}
+	var keyPEM []byte
+	if cm.config.CAKeyFile != "" {
+		if keyPEM, err = os.ReadFile(cm.config.CAKeyFile); err != nil {
+			return err
+		}
+	}
+	caCert, key, chain, err := parseCAPEM(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	return cm.installCA(caCert, key, chain, false)
+}
+
+// installCA validates and activates a CA. Cached leafs are dropped since they
+// were signed by the previous one.
+func (cm *CertManager) installCA(caCert *x509.Certificate, key crypto.Signer, chain []*x509.Certificate, persist bool) error {
+	if err := validateCA(caCert, key); err != nil {
+		return err
+	}
+
+	if persist {
+		if err := writeCAFiles(caCert, key, chain); err != nil {
+			return err
+		}
+	}
+
+	cm.mu.Lock()
+	cm.CACert = caCert
+	cm.CAKey = key
+	cm.CAChain = chain
+	cm.mu.Unlock()
+
+	cm.leafs.purge()
+	cm.listeners.Notify()
+	return nil
+}
+
+// OnChange registers a callback invoked every time the CA is replaced.
+func (cm *CertManager) OnChange(fn func()) {
+	cm.listeners.Add(fn)
+}
+
+func parseCAPEM(certPEM, keyPEM []byte) (*x509.Certificate, crypto.Signer, []*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	var key crypto.Signer
+
+	for _, data := range [][]byte{certPEM, keyPEM} {
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			switch {
+			case block.Type == "CERTIFICATE":
+				cert, err := x509.ParseCertificate(block.Bytes)
+				if err != nil {
+					return nil, nil, nil, fmt.Errorf("failed to parse certificate: %v", err)
+				}
+				certs = append(certs, cert)
+			case key == nil && bytes.HasSuffix([]byte(block.Type), []byte("PRIVATE KEY")):
+				parsed, err := parsePrivateKey(block.Bytes)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				key = parsed
+			}
+		}
+	}
+
+	if len(certs) == 0 {
+		return nil, nil, nil, fmt.Errorf("no certificate found")
+	}
+	if key == nil {
+		return nil, nil, nil, fmt.Errorf("no private key found")
+	}
+
+	// The CA is the certificate matching the key, wherever it is in the bundle
+	for i, cert := range certs {
+		if publicKeysEqual(cert.PublicKey, key.Public()) {
+			rest := append(append([]*x509.Certificate{}, certs[:i]...), certs[i+1:]...)
+			return cert, key, orderChain(cert, rest), nil
+		}
+	}
+	return nil, nil, nil, fmt.Errorf("private key does not match any certificate")
+}
+
+func parseCAPKCS12(data []byte, password string) (*x509.Certificate, crypto.Signer, []*x509.Certificate, error) {
+	rawKey, caCert, chain, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to decode PKCS#12: %v", err)
+	}
+	key, ok := rawKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("unsupported private key type %T", rawKey)
+	}
+	return caCert, key, orderChain(caCert, chain), nil
+}
+
+// validateCA makes sure the certificate can actually sign leafs.
+func validateCA(caCert *x509.Certificate, key crypto.Signer) error {
+	if !caCert.BasicConstraintsValid || !caCert.IsCA {
+		return fmt.Errorf("certificate %q is not a CA", caCert.Subject.CommonName)
+	}
+	if caCert.KeyUsage != 0 && caCert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return fmt.Errorf("certificate %q is not allowed to sign certificates", caCert.Subject.CommonName)
+	}
+	now := time.Now()
+	if now.Before(caCert.NotBefore) || now.After(caCert.NotAfter) {
+		return fmt.Errorf("certificate %q is not valid at this time", caCert.Subject.CommonName)
+	}
+	if !publicKeysEqual(caCert.PublicKey, key.Public()) {
+		return fmt.Errorf("private key does not match certificate %q", caCert.Subject.CommonName)
+	}
+	return nil
+}
+
+// orderChain returns the certificates that form the issuer chain of cert, from
+// its direct issuer upwards. Unrelated certificates are dropped.
+func orderChain(cert *x509.Certificate, pool []*x509.Certificate) []*x509.Certificate {
+	var chain []*x509.Certificate
+	current := cert
+	remaining := append([]*x509.Certificate{}, pool...)
+	for !isSelfSigned(current) {
+		found := -1
+		for i, candidate := range remaining {
+			if current.CheckSignatureFrom(candidate) == nil {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			break
+		}
+		current = remaining[found]
+		chain = append(chain, current)
+		remaining = append(remaining[:found], remaining[found+1:]...)
+	}
+	return chain
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
+
+// writeCAFiles persists the CA, keeping the previous files as .bak.
+func writeCAFiles(caCert *x509.Certificate, key crypto.Signer, chain []*x509.Certificate) error {
+	keyPEM, err := encodePrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := backupFile(caCertFile, 0644); err != nil {
+		return err
+	}
+	if err := backupFile(caKeyFile, 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(caCertFile, encodeCertificates(caCert, chain), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(caKeyFile, keyPEM, 0600)
+}
+
+// backupFile copies path to path.bak, if path exists.
+func backupFile(path string, perm os.FileMode) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+".bak", data, perm)
+}
+
+func encodeCertificates(caCert *x509.Certificate, chain []*x509.Certificate) []byte {
+	var buf bytes.Buffer
+	for _, cert := range append([]*x509.Certificate{caCert}, chain...) {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return buf.Bytes()
+}
+
+// CAChainPEM returns the CA certificate followed by its intermediate chain, as
+// served to devices that need to trust the proxy.
+func (cm *CertManager) CAChainPEM() []byte {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return encodeCertificates(cm.CACert, cm.CAChain)
+}
+
+// leafChain returns the certificates sent after the leaf in handshakes: the
+// signing CA and its intermediates, leaving out self-signed roots.
+func (cm *CertManager) leafChain() [][]byte {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	var chain [][]byte
+	for _, cert := range append([]*x509.Certificate{cm.CACert}, cm.CAChain...) {
+		if !isSelfSigned(cert) {
+			chain = append(chain, cert.Raw)
+		}
+	}
+	return chain
+}
@@ -0,0 +1,172 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// CAInfo describes the active CA for display in the app.
+type CAInfo struct {
+	Subject           string    `json:"subject"`
+	Issuer            string    `json:"issuer"`
+	SerialNumber      string    `json:"serial_number"`
+	NotBefore         time.Time `json:"not_before"`
+	NotAfter          time.Time `json:"not_after"`
+	KeyType           string    `json:"key_type"`
+	FingerprintSHA256 string    `json:"fingerprint_sha256"`
+	FingerprintSHA1   string    `json:"fingerprint_sha1"`
+	// Subjects of the intermediates above the CA, for imported CAs
+	Chain []string `json:"chain,omitempty"`
+}
+
+func (cm *CertManager) CAInfo() CAInfo {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	sum256 := sha256.Sum256(cm.CACert.Raw)
+	sum1 := sha1.Sum(cm.CACert.Raw)
+	info := CAInfo{
+		Subject:           cm.CACert.Subject.String(),
+		Issuer:            cm.CACert.Issuer.String(),
+		SerialNumber:      formatFingerprint(cm.CACert.SerialNumber.Bytes()),
+		NotBefore:         cm.CACert.NotBefore,
+		NotAfter:          cm.CACert.NotAfter,
+		KeyType:           KeyDescription(cm.CACert.PublicKey),
+		FingerprintSHA256: formatFingerprint(sum256[:]),
+		FingerprintSHA1:   formatFingerprint(sum1[:]),
+	}
+	for _, cert := range cm.CAChain {
+		info.Chain = append(info.Chain, cert.Subject.String())
+	}
+	return info
+}
+
+// CertificateSummary is a compact description of a certificate, used to
+// record what an upstream server presented.
+type CertificateSummary struct {
+	Subject           string    `json:"subject"`
+	Issuer            string    `json:"issuer"`
+	SerialNumber      string    `json:"serial_number"`
+	NotBefore         time.Time `json:"not_before"`
+	NotAfter          time.Time `json:"not_after"`
+	DNSNames          []string  `json:"dns_names,omitempty"`
+	KeyType           string    `json:"key_type"`
+	FingerprintSHA256 string    `json:"fingerprint_sha256"`
+}
+
+func Summarize(chain []*x509.Certificate) []CertificateSummary {
+	summaries := make([]CertificateSummary, 0, len(chain))
+	for _, cert := range chain {
+		sum := sha256.Sum256(cert.Raw)
+		summaries = append(summaries, CertificateSummary{
+			Subject:           cert.Subject.String(),
+			Issuer:            cert.Issuer.String(),
+			SerialNumber:      formatFingerprint(cert.SerialNumber.Bytes()),
+			NotBefore:         cert.NotBefore,
+			NotAfter:          cert.NotAfter,
+			DNSNames:          cert.DNSNames,
+			KeyType:           KeyDescription(cert.PublicKey),
+			FingerprintSHA256: formatFingerprint(sum[:]),
+		})
+	}
+	return summaries
+}
+
+// CADER returns the CA certificate in DER format.
+func (cm *CertManager) CADER() []byte {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return append([]byte{}, cm.CACert.Raw...)
+}
+
+// formatFingerprint renders bytes as colon separated upper-case hex.
+func formatFingerprint(b []byte) string {
+	h := strings.ToUpper(hex.EncodeToString(b))
+	parts := make([]string, 0, len(h)/2)
+	for i := 0; i+2 <= len(h); i += 2 {
+		parts = append(parts, h[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
+
+var mobileConfigTemplate = template.Must(template.New("mobileconfig").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>PayloadContent</key>
+	<array>
+		<dict>
+			<key>PayloadCertificateFileName</key>
+			<string>proxy-ca.cer</string>
+			<key>PayloadContent</key>
+			<data>{{.Certificate}}</data>
+			<key>PayloadDescription</key>
+			<string>Adds the PacketPeek root certificate</string>
+			<key>PayloadDisplayName</key>
+			<string>{{.Name}}</string>
+			<key>PayloadIdentifier</key>
+			<string>com.packetpeek.ca.{{.CertUUID}}</string>
+			<key>PayloadType</key>
+			<string>com.apple.security.root</string>
+			<key>PayloadUUID</key>
+			<string>{{.CertUUID}}</string>
+			<key>PayloadVersion</key>
+			<integer>1</integer>
+		</dict>
+	</array>
+	<key>PayloadDisplayName</key>
+	<string>PacketPeek CA</string>
+	<key>PayloadIdentifier</key>
+	<string>com.packetpeek.profile.{{.ProfileUUID}}</string>
+	<key>PayloadRemovalDisallowed</key>
+	<false/>
+	<key>PayloadType</key>
+	<string>Configuration</string>
+	<key>PayloadUUID</key>
+	<string>{{.ProfileUUID}}</string>
+	<key>PayloadVersion</key>
+	<integer>1</integer>
+</dict>
+</plist>
+`))
+
+// MobileConfig returns an (unsigned) iOS configuration profile that installs
+// the CA certificate.
+func (cm *CertManager) MobileConfig() ([]byte, error) {
+	cm.mu.RLock()
+	der := cm.CACert.Raw
+	name := cm.CACert.Subject.CommonName
+	cm.mu.RUnlock()
+
+	var buf bytes.Buffer
+	err := mobileConfigTemplate.Execute(&buf, struct {
+		Certificate, Name, CertUUID, ProfileUUID string
+	}{
+		Certificate: base64.StdEncoding.EncodeToString(der),
+		Name:        html.EscapeString(name),
+		CertUUID:    newUUID(),
+		ProfileUUID: newUUID(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return strings.ToUpper(fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]))
+}
@@ -1,16 +1,22 @@
 package cert
 
 import (
+	"container/list"
+	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log"
 	"math/big"
 	"net"
 	"os"
+	"proxy_core/internal/notify"
+	"sync"
 	"time"
 )
 
@@ -19,21 +25,84 @@ const (
 	caCertFile = "ca.pem"
 )
 
+type Config struct {
+	// CAKeyAlgorithm is used when a new CA has to be generated
+	CAKeyAlgorithm KeyAlgorithm
+	// LeafKeyAlgorithm is the preferred key type for leaf certificates. Clients
+	// that cannot handle it get an ECDSA P-256 or RSA 2048 leaf instead.
+	LeafKeyAlgorithm KeyAlgorithm
+
+	// CACertFile/CAKeyFile load an existing CA in PEM format instead of the
+	// generated one. The key may also be bundled in CACertFile.
+	CACertFile string
+	CAKeyFile  string
+	// CAPKCS12File loads an existing CA from a PKCS#12 bundle
+	CAPKCS12File     string
+	CAPKCS12Password string
+
+	// WildcardLeafs issues "*.parent" leafs so sibling hosts share one certificate
+	WildcardLeafs bool
+	// MirrorUpstreamSANs copies the SAN list of the real server certificate once
+	// the proxy has connected to that host upstream
+	MirrorUpstreamSANs bool
+
+	// LeafCacheSize is the maximum number of leaf certificates kept in memory
+	LeafCacheSize int
+	// LeafCacheDir, when set, persists issued leaf certificates between restarts
+	LeafCacheDir string
+}
+
 type CertManager struct {
 	CACert *x509.Certificate
-	CAKey  *rsa.PrivateKey
+	CAKey  crypto.Signer
+	// CAChain holds the intermediates above CACert, if any
+	CAChain []*x509.Certificate
+	mu      sync.RWMutex
+
+	config    Config
+	leafs     *leafCache
+	listeners notify.Listeners
+	// upstreamCerts is an LRU of the origin certificates seen per host, as
+	// large as the leaf cache
+	upstreamCerts map[string]*list.Element
+	upstreamOrder *list.List
 }
 
-func NewCertManager() (*CertManager, error) {
-	cm := &CertManager{}
+func NewCertManager(config Config) (*CertManager, error) {
+	if config.CAKeyAlgorithm == "" {
+		config.CAKeyAlgorithm = KeyRSA4096
+	}
+	if config.LeafKeyAlgorithm == "" {
+		config.LeafKeyAlgorithm = KeyECDSAP256
+	}
+
+	cm := &CertManager{
+		config:        config,
+		leafs:         newLeafCache(config.LeafCacheSize),
+		upstreamCerts: make(map[string]*list.Element),
+		upstreamOrder: list.New(),
+	}
+
+	// Use the CA supplied by the user, if any
+	if config.CACertFile != "" || config.CAPKCS12File != "" {
+		if err := cm.loadConfiguredCA(); err != nil {
+			return nil, fmt.Errorf("failed to load CA: %v", err)
+		}
+		return cm, nil
+	}
 
-	// Try to load existing CA
-	if err := cm.loadCA(); err == nil {
+	// Try to load existing CA. Only a missing one is replaced: the files may
+	// hold an imported CA whose key exists nowhere else
+	err := cm.loadCA()
+	if err == nil {
 		return cm, nil
 	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to load CA from %s and %s (fix or remove them): %v", caCertFile, caKeyFile, err)
+	}
 
 	// Generate new CA if not exists
-	if err := cm.generateCA(); err != nil {
+	if err := cm.generateCA(CAOptions{}); err != nil {
 		return nil, fmt.Errorf("failed to generate CA: %v", err)
 	}
 
@@ -46,81 +115,182 @@ func (cm *CertManager) loadCA() error {
 	if err != nil {
 		return err
 	}
-	keyBlock, _ := pem.Decode(keyData)
-	if keyBlock == nil {
-		return fmt.Errorf("failed to decode CA key")
-	}
-	cm.CAKey, err = x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+
+	// Load CA Certificate, followed by its chain for imported CAs
+	certData, err := os.ReadFile(caCertFile)
 	if err != nil {
 		return err
 	}
 
-	// Load CA Certificate
-	certData, err := os.ReadFile(caCertFile)
+	caCert, key, chain, err := parseCAPEM(certData, keyData)
 	if err != nil {
 		return err
 	}
-	certBlock, _ := pem.Decode(certData)
-	if certBlock == nil {
-		return fmt.Errorf("failed to decode CA certificate")
+	return cm.installCA(caCert, key, chain, false)
+}
+
+// CAOptions customizes a generated CA. Empty fields use the defaults.
+type CAOptions struct {
+	CommonName    string       `json:"common_name,omitempty"`
+	Organization  string       `json:"organization,omitempty"`
+	Country       string       `json:"country,omitempty"`
+	KeyAlgorithm  KeyAlgorithm `json:"key_algorithm,omitempty"`
+	ValidityYears int          `json:"validity_years,omitempty"`
+}
+
+func (cm *CertManager) generateCA(opts CAOptions) error {
+	if opts.CommonName == "" {
+		opts.CommonName = "ProxyCore Root CA"
+	}
+	if opts.Organization == "" {
+		opts.Organization = "ProxyCore CA"
+	}
+	if opts.Country == "" {
+		opts.Country = "IT"
 	}
-	cm.CACert, err = x509.ParseCertificate(certBlock.Bytes)
+	if opts.KeyAlgorithm == "" {
+		opts.KeyAlgorithm = cm.config.CAKeyAlgorithm
+	}
+	if opts.ValidityYears <= 0 {
+		opts.ValidityYears = 10
+	}
+
+	key, err := generateKey(opts.KeyAlgorithm)
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
-
-func (cm *CertManager) generateCA() error {
-	key, err := rsa.GenerateKey(rand.Reader, 4096) // Aumentato a 4096 bit per maggiore sicurezza
+	// Serial casuale, così CA generate su macchine diverse non collidono
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
 	if err != nil {
 		return err
 	}
 
 	template := &x509.Certificate{
-		SerialNumber: big.NewInt(1),
+		SerialNumber: serialNumber,
 		Subject: pkix.Name{
-			Organization: []string{"ProxyCore CA"},
-			CommonName:   "ProxyCore Root CA",
-			Country:      []string{"IT"},
+			Organization: []string{opts.Organization},
+			CommonName:   opts.CommonName,
+			Country:      []string{opts.Country},
 		},
-		NotBefore:             time.Now().Add(-time.Hour * 24), // Valido da ieri
-		NotAfter:              time.Now().AddDate(10, 0, 0),    // Valido per 10 anni
-		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		NotBefore:             time.Now().Add(-time.Hour * 24),              // Valido da ieri
+		NotAfter:              time.Now().AddDate(opts.ValidityYears, 0, 0), // Valido per 10 anni di default
+		KeyUsage:              x509.KeyUsageCertSign | keyUsageFor(key.Public()),
 		BasicConstraintsValid: true,
 		IsCA:                  true,
 		MaxPathLen:            0, // Non permettere sub-CA
 	}
 
-	derBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
 	if err != nil {
 		return err
 	}
-
-	// Save CA certificate
-	certOut, err := os.Create(caCertFile)
+	caCert, err := x509.ParseCertificate(derBytes)
 	if err != nil {
 		return err
 	}
-	defer certOut.Close()
-	pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
 
-	// Save CA private key
-	keyOut, err := os.Create(caKeyFile)
-	if err != nil {
-		return err
-	}
-	defer keyOut.Close()
-	pem.Encode(keyOut, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	// Save CA certificate and private key
+	return cm.installCA(caCert, key, nil, true)
+}
 
-	cm.CACert = template
-	cm.CAKey = key
-	return nil
+// RegenerateCA replaces the current CA with a freshly generated one. Devices
+// will have to trust the new certificate again.
+func (cm *CertManager) RegenerateCA(opts CAOptions) error {
+	if opts.KeyAlgorithm != "" {
+		if _, err := ParseKeyAlgorithm(string(opts.KeyAlgorithm)); err != nil {
+			return err
+		}
+	}
+	return cm.generateCA(opts)
 }
 
+// GenerateCertificate returns a leaf certificate for host signed by the CA,
+// using the configured leaf key algorithm. Leafs are cached per hostname, so
+// repeated handshakes reuse the same one.
 func (cm *CertManager) GenerateCertificate(host string) (*tls.Certificate, error) {
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	return cm.leafFor(cm.namesFor(stripPort(host)), cm.config.LeafKeyAlgorithm)
+}
+
+// GetCertificate picks a leaf for the TLS handshake, falling back to more
+// widely supported key types when the client cannot use the preferred one.
+// It can be used directly as tls.Config.GetCertificate.
+func (cm *CertManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	names := cm.namesFor(stripPort(hello.ServerName))
+	return cm.selectLeaf(hello, func(alg KeyAlgorithm) (*tls.Certificate, error) {
+		return cm.leafFor(names, alg)
+	})
+}
+
+// GetMimicCertificate is like GetCertificate but copies subject, SANs and
+// validity from the certificate the real origin presented. The leaf is cached
+// per origin (what the proxy dials and the SNI), so fetch is only called to
+// get the origin certificate when no fresh mimicked leaf is cached.
+func (cm *CertManager) GetMimicCertificate(hello *tls.ClientHelloInfo, origin string, fetch func() (*x509.Certificate, error)) (*tls.Certificate, error) {
+	var upstream *x509.Certificate
+	var fetchErr error
+	names := func() (leafNames, error) {
+		if upstream == nil && fetchErr == nil {
+			upstream, fetchErr = fetch()
+		}
+		if fetchErr != nil {
+			return leafNames{}, fetchErr
+		}
+		return mimicNames(origin, upstream), nil
+	}
+	return cm.selectLeaf(hello, func(alg KeyAlgorithm) (*tls.Certificate, error) {
+		return cm.cachedLeaf("mimic-"+origin+"-"+string(alg), alg, names)
+	})
+}
+
+func (cm *CertManager) selectLeaf(hello *tls.ClientHelloInfo, leafFor func(KeyAlgorithm) (*tls.Certificate, error)) (*tls.Certificate, error) {
+	var lastErr error
+	for _, alg := range []KeyAlgorithm{cm.config.LeafKeyAlgorithm, KeyECDSAP256, KeyRSA2048} {
+		cert, err := leafFor(alg)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if hello.SupportsCertificate(cert) == nil {
+			return cert, nil
+		}
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return leafFor(KeyRSA2048)
+}
+
+func (cm *CertManager) leafFor(names leafNames, alg KeyAlgorithm) (*tls.Certificate, error) {
+	return cm.cachedLeaf(names.cacheKey+"-"+string(alg), alg, func() (leafNames, error) {
+		return names, nil
+	})
+}
+
+// cachedLeaf returns the leaf cached under cacheKey, in memory or on disk,
+// generating it with the names returned by names when missing.
+func (cm *CertManager) cachedLeaf(cacheKey string, alg KeyAlgorithm, names func() (leafNames, error)) (*tls.Certificate, error) {
+	return cm.leafs.getOrCreate(cacheKey, func() (*tls.Certificate, error) {
+		if cert, ok := cm.loadLeafFromDisk(cacheKey); ok {
+			return cert, nil
+		}
+		leafNames, err := names()
+		if err != nil {
+			return nil, err
+		}
+		return cm.generateLeaf(leafNames, cacheKey, alg)
+	})
+}
+
+func stripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+func (cm *CertManager) generateLeaf(names leafNames, cacheKey string, alg KeyAlgorithm) (*tls.Certificate, error) {
+	key, err := generateKey(alg)
 	if err != nil {
 		return nil, err
 	}
@@ -130,52 +300,54 @@ func (cm *CertManager) GenerateCertificate(host string) (*tls.Certificate, error
 		return nil, err
 	}
 
-	// Estrai il dominio base per il Subject CN e gestisci IP
-	hostname := host
-	var ips []net.IP
-
-	if h, _, err := net.SplitHostPort(host); err == nil {
-		hostname = h
+	subject := pkix.Name{
+		Organization: []string{"ProxyCore Dynamic Cert"},
+		CommonName:   names.commonName,
+		Country:      []string{"IT"},
 	}
-
-	// Controlla se l'hostname è un IP
-	ip := net.ParseIP(hostname)
-	if ip != nil {
-		ips = append(ips, ip)
-	} else {
-		// Prova a risolvere il DNS
-		if resolved, err := net.LookupIP(hostname); err == nil {
-			ips = append(ips, resolved...)
-		}
+	if names.subject != nil {
+		subject = *names.subject
 	}
 
 	template := &x509.Certificate{
-		SerialNumber: serialNumber,
-		Subject: pkix.Name{
-			Organization: []string{"ProxyCore Dynamic Cert"},
-			CommonName:   hostname,
-			Country:      []string{"IT"},
-		},
+		SerialNumber:          serialNumber,
+		Subject:               subject,
 		NotBefore:             time.Now().Add(-time.Hour * 24), // Valido da ieri
 		NotAfter:              time.Now().AddDate(1, 0, 0),     // Valido per 1 anno
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		KeyUsage:              keyUsageFor(key.Public()),
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
-		DNSNames:              []string{hostname},
-		IPAddresses:           ips,
+		DNSNames:              names.dnsNames,
+		IPAddresses:           names.ips,
+	}
+	if !names.notAfter.IsZero() {
+		template.NotBefore = names.notBefore
+		template.NotAfter = names.notAfter
 	}
 
-	derBytes, err := x509.CreateCertificate(rand.Reader, template, cm.CACert, &key.PublicKey, cm.CAKey)
+	cm.mu.RLock()
+	caCert, caKey := cm.CACert, cm.CAKey
+	cm.mu.RUnlock()
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, caCert, key.Public(), caKey)
 	if err != nil {
 		return nil, err
 	}
 
 	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
-	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	keyPEM, err := encodePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
 
 	cert, err := tls.X509KeyPair(certPEM, keyPEM)
 	if err != nil {
 		return nil, err
 	}
+	cert.Certificate = append(cert.Certificate, cm.leafChain()...)
+
+	if err := cm.saveLeafToDisk(cacheKey, certPEM, keyPEM); err != nil {
+		log.Printf("Failed to persist certificate for %s: %v", names.commonName, err)
+	}
 	return &cert, nil
 }
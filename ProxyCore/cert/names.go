@@ -0,0 +1,141 @@
+package cert
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// leafNames is what goes into a leaf besides the key: the subject common name
+// and the SANs, plus subject and validity when mimicking an upstream
+// certificate. Leafs with the same names share a cache entry.
+type leafNames struct {
+	commonName string
+	dnsNames   []string
+	ips        []net.IP
+	cacheKey   string
+
+	subject   *pkix.Name
+	notBefore time.Time
+	notAfter  time.Time
+}
+
+// namesFor decides the names of the leaf for hostname. Nothing is resolved via
+// DNS: IPs are only included when the client connected to an IP literal.
+func (cm *CertManager) namesFor(hostname string) leafNames {
+	if ip := net.ParseIP(hostname); ip != nil {
+		return leafNames{commonName: hostname, ips: []net.IP{ip}, cacheKey: hostname}
+	}
+
+	if cm.config.MirrorUpstreamSANs {
+		if upstream, ok := cm.upstreamCertificate(hostname); ok {
+			return mirroredNames(hostname, upstream)
+		}
+	}
+
+	if cm.config.WildcardLeafs {
+		if wildcard, parent, ok := wildcardFor(hostname); ok {
+			return leafNames{commonName: wildcard, dnsNames: []string{wildcard, parent}, cacheKey: wildcard}
+		}
+	}
+
+	return leafNames{commonName: hostname, dnsNames: []string{hostname}, cacheKey: hostname}
+}
+
+// wildcardFor returns "*.api.example.com" for "v1.api.example.com". Hosts whose
+// parent is a public suffix (e.g. "example.co.uk") keep an exact certificate,
+// since clients reject wildcards over public suffixes.
+func wildcardFor(hostname string) (wildcard, parent string, ok bool) {
+	i := strings.IndexByte(hostname, '.')
+	if i <= 0 {
+		return "", "", false
+	}
+	parent = hostname[i+1:]
+	registrable, err := publicsuffix.EffectiveTLDPlusOne(hostname)
+	if err != nil || len(parent) < len(registrable) {
+		return "", "", false
+	}
+	return "*." + parent, parent, true
+}
+
+// mirroredNames copies the SANs of the real server certificate, making sure the
+// requested hostname is still covered.
+func mirroredNames(hostname string, upstream *x509.Certificate) leafNames {
+	names := leafNames{
+		commonName: hostname,
+		dnsNames:   append([]string{}, upstream.DNSNames...),
+		ips:        append([]net.IP{}, upstream.IPAddresses...),
+	}
+	if upstream.VerifyHostname(hostname) != nil {
+		names.dnsNames = append(names.dnsNames, hostname)
+	}
+	if upstream.Subject.CommonName != "" {
+		names.commonName = upstream.Subject.CommonName
+	}
+
+	sum := sha256.Sum256(upstream.Raw)
+	names.cacheKey = hostname + "-mirror-" + hex.EncodeToString(sum[:4])
+	return names
+}
+
+// mimicNames copies subject, SANs and validity from the upstream certificate
+// of origin.
+func mimicNames(origin string, upstream *x509.Certificate) leafNames {
+	subject := upstream.Subject
+	return leafNames{
+		commonName: upstream.Subject.CommonName,
+		dnsNames:   append([]string{}, upstream.DNSNames...),
+		ips:        append([]net.IP{}, upstream.IPAddresses...),
+		cacheKey:   "mimic-" + origin,
+		subject:    &subject,
+		notBefore:  upstream.NotBefore,
+		notAfter:   upstream.NotAfter,
+	}
+}
+
+// RecordUpstreamCertificate remembers the certificate presented by the origin
+// for host, so later leafs can mirror its SAN list.
+func (cm *CertManager) RecordUpstreamCertificate(host string, upstream *x509.Certificate) {
+	if !cm.config.MirrorUpstreamSANs || upstream == nil {
+		return
+	}
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if elem, ok := cm.upstreamCerts[hostname]; ok {
+		elem.Value.(*upstreamEntry).cert = upstream
+		cm.upstreamOrder.MoveToFront(elem)
+		return
+	}
+	cm.upstreamCerts[hostname] = cm.upstreamOrder.PushFront(&upstreamEntry{host: hostname, cert: upstream})
+	for cm.upstreamOrder.Len() > cm.leafs.capacity {
+		oldest := cm.upstreamOrder.Back()
+		cm.upstreamOrder.Remove(oldest)
+		delete(cm.upstreamCerts, oldest.Value.(*upstreamEntry).host)
+	}
+}
+
+type upstreamEntry struct {
+	host string
+	cert *x509.Certificate
+}
+
+func (cm *CertManager) upstreamCertificate(hostname string) (*x509.Certificate, bool) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	elem, ok := cm.upstreamCerts[hostname]
+	if !ok {
+		return nil, false
+	}
+	cm.upstreamOrder.MoveToFront(elem)
+	return elem.Value.(*upstreamEntry).cert, true
+}
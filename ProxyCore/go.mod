@@ -4,4 +4,17 @@ go 1.24.2
 
 require github.com/gorilla/websocket v1.5.3
 
-require github.com/mssola/user_agent v0.6.0 // indirect
+require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/klauspost/compress v1.18.0
+	github.com/mssola/user_agent v0.6.0
+	go.etcd.io/bbolt v1.4.0
+	golang.org/x/net v0.40.0
+	software.sslmate.com/src/go-pkcs12 v0.7.3
+)
+
+require (
+	golang.org/x/crypto v0.38.0 // indirect
+	golang.org/x/sys v0.33.0 // indirect
+	golang.org/x/text v0.25.0 // indirect
+)
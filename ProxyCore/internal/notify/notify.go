@@ -0,0 +1,30 @@
+// Package notify holds the change listeners shared by the proxy managers and
+// the certificate manager.
+package notify
+
+import "sync"
+
+// Listeners is a list of callbacks run after every change of the owner. The
+// zero value is ready to use.
+type Listeners struct {
+	mu  sync.Mutex
+	fns []func()
+}
+
+// Add registers a callback.
+func (l *Listeners) Add(fn func()) {
+	l.mu.Lock()
+	l.fns = append(l.fns, fn)
+	l.mu.Unlock()
+}
+
+// Notify runs the callbacks in the order they were added. They are called
+// without holding any lock, so they may use the owner freely.
+func (l *Listeners) Notify() {
+	l.mu.Lock()
+	fns := append([]func(){}, l.fns...)
+	l.mu.Unlock()
+	for _, fn := range fns {
+		fn()
+	}
+}
@@ -1,21 +1,91 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
+	"os"
+	"os/signal"
 	"proxy_core/api"
 	"proxy_core/cert"
 	"proxy_core/proxy"
+	"proxy_core/storage"
+	"syscall"
+	"time"
 )
 
 func main() {
+	leafCacheDir := flag.String("leaf-cache-dir", "", "directory where issued leaf certificates are persisted (disabled if empty)")
+	leafCacheSize := flag.Int("leaf-cache-size", 1000, "maximum number of leaf certificates kept in memory")
+	caKeyAlg := flag.String("ca-key-alg", string(cert.KeyRSA4096), "key algorithm for a newly generated CA (rsa2048, rsa4096, ecdsa-p256, ecdsa-p384, ed25519)")
+	leafKeyAlg := flag.String("leaf-key-alg", string(cert.KeyECDSAP256), "preferred key algorithm for leaf certificates")
+	caCertFile := flag.String("ca-cert", "", "PEM file of an existing CA certificate (and optional chain) to use instead of the generated one")
+	caKeyFile := flag.String("ca-key", "", "PEM file of the private key for -ca-cert")
+	caP12File := flag.String("ca-p12", "", "PKCS#12 bundle of an existing CA to use instead of the generated one")
+	caP12Password := flag.String("ca-p12-password", "", "password for -ca-p12")
+	wildcardLeafs := flag.Bool("wildcard-leafs", false, "issue wildcard certificates for the parent domain so sibling hosts share one")
+	mirrorSANs := flag.Bool("mirror-upstream-sans", false, "copy the SAN list of the real server certificate once the host was contacted")
+	mimicUpstream := flag.Bool("mimic-upstream", false, "handshake with the origin first and copy its certificate subject, SANs and validity")
+	disableHTTP2 := flag.Bool("disable-http2", false, "speak only HTTP/1.1 with intercepted clients and origins")
+	maxCaptureSize := flag.Int("max-capture-size", 4<<20, "maximum number of bytes of each response body kept in the logs")
+	sessionDB := flag.String("session-db", "sessions.db", "database file where captured sessions are kept (disabled if empty)")
+	retentionLogs := flag.Int("retention-max-logs", 100000, "maximum number of logs kept across all sessions (0 disables)")
+	retentionAge := flag.Duration("retention-max-age", 0, "drop stored logs older than this (0 disables)")
+	retentionSize := flag.Int64("retention-max-size", 1<<30, "maximum bytes of stored logs, bodies included (0 disables)")
+	breakpointTimeout := flag.Duration("breakpoint-timeout", time.Minute, "how long a request or response waits at a breakpoint before it continues unchanged")
+	pinningThreshold := flag.Int("pinning-passthrough-after", 0, "tunnel a host without decryption after this many rejected handshakes (0 disables)")
+	flag.Parse()
+
+	caAlg, err := cert.ParseKeyAlgorithm(*caKeyAlg)
+	if err != nil {
+		log.Fatalf("Invalid -ca-key-alg: %v", err)
+	}
+	leafAlg, err := cert.ParseKeyAlgorithm(*leafKeyAlg)
+	if err != nil {
+		log.Fatalf("Invalid -leaf-key-alg: %v", err)
+	}
+
 	// Create certificate manager
-	certManager, err := cert.NewCertManager()
+	certManager, err := cert.NewCertManager(cert.Config{
+		CAKeyAlgorithm:     caAlg,
+		LeafKeyAlgorithm:   leafAlg,
+		CACertFile:         *caCertFile,
+		CAKeyFile:          *caKeyFile,
+		CAPKCS12File:       *caP12File,
+		CAPKCS12Password:   *caP12Password,
+		WildcardLeafs:      *wildcardLeafs,
+		MirrorUpstreamSANs: *mirrorSANs,
+		LeafCacheSize:      *leafCacheSize,
+		LeafCacheDir:       *leafCacheDir,
+	})
 	if err != nil {
 		log.Fatalf("Failed to create certificate manager: %v", err)
 	}
 
+	// Open session storage
+	var store proxy.LogStore
+	var boltStore *storage.BoltStore
+	if *sessionDB != "" {
+		boltStore, err = storage.NewBoltStore(*sessionDB, storage.Retention{
+			MaxLogs: *retentionLogs,
+			MaxAge:  *retentionAge,
+			MaxSize: *retentionSize,
+		})
+		if err != nil {
+			log.Fatalf("Failed to open session storage: %v", err)
+		}
+		store = boltStore
+	}
+
 	// Create proxy server
-	proxyServer := proxy.NewProxyServer(certManager)
+	proxyServer := proxy.NewProxyServer(certManager, proxy.Config{
+		MimicUpstream:               *mimicUpstream,
+		PinningPassThroughThreshold: *pinningThreshold,
+		DisableHTTP2:                *disableHTTP2,
+		MaxCaptureSize:              *maxCaptureSize,
+		Store:                       store,
+		BreakpointTimeout:           *breakpointTimeout,
+	})
 
 	// Create API server
 	apiServer := api.NewAPIServer(proxyServer)
@@ -28,8 +98,22 @@ func main() {
 		}
 	}()
 
-	log.Printf("Starting API server on :8081")
-	if err := apiServer.Start(":8081"); err != nil {
-		log.Fatalf("API server error: %v", err)
+	go func() {
+		log.Printf("Starting API server on :8081")
+		if err := apiServer.Start(":8081"); err != nil {
+			log.Fatalf("API server error: %v", err)
+		}
+	}()
+
+	// Write out the queued logs and close the session database on exit
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	log.Printf("Shutting down")
+	proxyServer.Close()
+	if boltStore != nil {
+		if err := boltStore.Close(); err != nil {
+			log.Printf("Failed to close session storage: %v", err)
+		}
 	}
 }
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"mime"
+	"net/http"
+	"unicode/utf8"
+)
+
+// Bodies are kept as raw bytes in RawRequestBody/RawResponseBody. In JSON
+// they are exposed as text when they are valid UTF-8 and base64 otherwise,
+// with the *_base64 flag telling the two apart.
+
+func (l *RequestLog) setRequestBody(body capturedBody, contentType string) {
+	l.RawRequestBody = body.data
+	l.RequestBody, l.RequestBodyBase64 = bodyText(body.data)
+	l.RequestMIMEType = detectMIMEType(contentType, body.data)
+	l.RequestBodySize = body.size
+	l.RequestBodyDecodedSize = body.decodedSize
+	l.RequestBodyTruncated = body.truncated
+}
+
+func (l *RequestLog) setResponseBody(body capturedBody, contentType string) {
+	l.RawResponseBody = body.data
+	l.ResponseBody, l.ResponseBodyBase64 = bodyText(body.data)
+	l.ResponseMIMEType = detectMIMEType(contentType, body.data)
+	l.ResponseBodySize = body.size
+	l.ResponseBodyDecodedSize = body.decodedSize
+	l.ResponseBodyTruncated = body.truncated
+}
+
+func bodyText(data []byte) (string, bool) {
+	if utf8.Valid(data) {
+		return string(data), false
+	}
+	return base64.StdEncoding.EncodeToString(data), true
+}
+
+// detectMIMEType returns the media type declared by the Content-Type header
+// or, when it is missing or generic, the one sniffed from the content.
+func detectMIMEType(contentType string, data []byte) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && mediaType != "application/octet-stream" {
+		return mediaType
+	}
+	if len(data) == 0 {
+		return ""
+	}
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	return mediaType
+}
+
+// WithoutBodies returns a copy of the entry without the body contents, for
+// stores that keep bodies apart. RestoreBodies puts them back.
+func (l RequestLog) WithoutBodies() RequestLog {
+	l.RequestBody, l.ResponseBody = "", ""
+	l.RawRequestBody, l.RawResponseBody = nil, nil
+	return l
+}
+
+func (l *RequestLog) RestoreBodies(request, response []byte) {
+	l.RawRequestBody = request
+	l.RequestBody, l.RequestBodyBase64 = bodyText(request)
+	l.RawResponseBody = response
+	l.ResponseBody, l.ResponseBodyBase64 = bodyText(response)
+}
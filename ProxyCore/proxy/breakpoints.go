@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"fmt"
+	"proxy_core/internal/notify"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// BreakpointRule pauses matching requests before they are forwarded and/or
+// their responses before they reach the client. Method, host and path match
+// like mock rules; a literal path ending in "*" matches as a prefix.
+type BreakpointRule struct {
+	ID       string `json:"id"`
+	Method   string `json:"method"`
+	Host     string `json:"host"`
+	Path     string `json:"path"`
+	IsRegex  bool   `json:"is_regex"`
+	Request  bool   `json:"request"`
+	Response bool   `json:"response"`
+	IsActive bool   `json:"is_active"`
+}
+
+type BreakpointManager struct {
+	rules     []BreakpointRule
+	regexes   map[string]*regexp.Regexp
+	mu        sync.RWMutex
+	file      string
+	listeners notify.Listeners
+}
+
+func NewBreakpointManager(configFile string) *BreakpointManager {
+	manager := &BreakpointManager{
+		regexes: make(map[string]*regexp.Regexp),
+		file:    configFile,
+	}
+	manager.loadFromFile()
+	return manager
+}
+
+func (m *BreakpointManager) loadFromFile() error {
+	var rules []BreakpointRule
+	if err := loadJSONFile(m.file, &rules); err != nil {
+		return err
+	}
+
+	generated := false
+	m.mu.Lock()
+	for _, rule := range rules {
+		if rule.ID == "" {
+			rule.ID = newID()
+			generated = true
+		}
+		if rule.IsRegex {
+			// Like mocks, rules with an invalid regex are kept but never match
+			if re, err := regexp.Compile(rule.Path); err == nil {
+				m.regexes[rule.ID] = re
+			}
+		}
+		m.rules = append(m.rules, rule)
+	}
+	m.mu.Unlock()
+
+	if generated {
+		return m.saveToFile()
+	}
+	return nil
+}
+
+func (m *BreakpointManager) saveToFile() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return saveJSONFile(m.file, m.rules)
+}
+
+// AddRule inserts a new rule or replaces the one with the same ID.
+func (m *BreakpointManager) AddRule(rule BreakpointRule) (BreakpointRule, error) {
+	if !rule.Request && !rule.Response {
+		return rule, fmt.Errorf("a breakpoint must pause the request, the response or both")
+	}
+	var re *regexp.Regexp
+	if rule.IsRegex {
+		var err error
+		if re, err = regexp.Compile(rule.Path); err != nil {
+			return rule, fmt.Errorf("invalid path regex: %v", err)
+		}
+	}
+
+	m.mu.Lock()
+	if rule.ID == "" {
+		rule.ID = newID()
+	}
+	replaced := false
+	for i := range m.rules {
+		if m.rules[i].ID == rule.ID {
+			m.rules[i] = rule
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		m.rules = append(m.rules, rule)
+	}
+	delete(m.regexes, rule.ID)
+	if re != nil {
+		m.regexes[rule.ID] = re
+	}
+	m.mu.Unlock()
+	err := m.saveToFile()
+	m.listeners.Notify()
+	return rule, err
+}
+
+func (m *BreakpointManager) DeleteRule(id string) error {
+	m.mu.Lock()
+	index := -1
+	for i := range m.rules {
+		if m.rules[i].ID == id {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		m.mu.Unlock()
+		return fmt.Errorf("breakpoint %s not found", id)
+	}
+	m.rules = append(m.rules[:index], m.rules[index+1:]...)
+	delete(m.regexes, id)
+	m.mu.Unlock()
+	err := m.saveToFile()
+	m.listeners.Notify()
+	return err
+}
+
+func (m *BreakpointManager) ListRules() []BreakpointRule {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]BreakpointRule{}, m.rules...)
+}
+
+// OnChange registers a callback invoked after every rule change.
+func (m *BreakpointManager) OnChange(fn func()) {
+	m.listeners.Add(fn)
+}
+
+// Match returns the first active rule for the stage (StageRequest or
+// StageResponse) that applies to the request.
+func (m *BreakpointManager) Match(stage, method, host, path string) (BreakpointRule, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, rule := range m.rules {
+		if !rule.IsActive {
+			continue
+		}
+		if (stage == StageRequest && !rule.Request) || (stage == StageResponse && !rule.Response) {
+			continue
+		}
+		if _, ok := matchMockMethod(rule.Method, method); !ok {
+			continue
+		}
+		if _, ok := matchHostPattern(rule.Host, host); !ok {
+			continue
+		}
+		if !m.matchPath(rule, path) {
+			continue
+		}
+		return rule, true
+	}
+	return BreakpointRule{}, false
+}
+
+func (m *BreakpointManager) matchPath(rule BreakpointRule, path string) bool {
+	if rule.IsRegex {
+		re, ok := m.regexes[rule.ID]
+		return ok && re.MatchString(path)
+	}
+	if rule.Path == "" || rule.Path == "*" {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(rule.Path, "*"); ok {
+		return strings.HasPrefix(path, prefix)
+	}
+	return rule.Path == path
+}
@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"fmt"
+	"proxy_core/internal/notify"
+	"sync"
+)
+
+// SavedRequest is a composer request kept in a collection.
+type SavedRequest struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	ComposeRequest
+}
+
+// Collection is a named set of saved requests, shared through the
+// collections file like the mocks.
+type Collection struct {
+	ID       string         `json:"id"`
+	Name     string         `json:"name"`
+	Requests []SavedRequest `json:"requests"`
+}
+
+type CollectionManager struct {
+	collections []Collection
+	mu          sync.RWMutex
+	file        string
+	listeners   notify.Listeners
+}
+
+func NewCollectionManager(configFile string) *CollectionManager {
+	manager := &CollectionManager{file: configFile}
+	manager.loadFromFile()
+	return manager
+}
+
+func (m *CollectionManager) loadFromFile() error {
+	var collections []Collection
+	if err := loadJSONFile(m.file, &collections); err != nil {
+		return err
+	}
+
+	generated := false
+	m.mu.Lock()
+	for _, collection := range collections {
+		collection, changed := withIDs(collection)
+		generated = generated || changed
+		m.collections = append(m.collections, collection)
+	}
+	m.mu.Unlock()
+
+	// Keep the generated IDs stable across restarts
+	if generated {
+		return m.saveToFile()
+	}
+	return nil
+}
+
+func (m *CollectionManager) saveToFile() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return saveJSONFile(m.file, m.collections)
+}
+
+// SaveCollection inserts a new collection or replaces the one with the same
+// ID. Missing IDs, of the collection or of its requests, are generated.
+func (m *CollectionManager) SaveCollection(collection Collection) (Collection, error) {
+	if collection.Name == "" {
+		return Collection{}, fmt.Errorf("%w collection: missing name", ErrInvalid)
+	}
+	collection, _ = withIDs(collection)
+
+	m.mu.Lock()
+	replaced := false
+	for i := range m.collections {
+		if m.collections[i].ID == collection.ID {
+			m.collections[i] = collection
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		m.collections = append(m.collections, collection)
+	}
+	m.mu.Unlock()
+	err := m.saveToFile()
+	m.listeners.Notify()
+	return collection, err
+}
+
+func (m *CollectionManager) DeleteCollection(id string) error {
+	m.mu.Lock()
+	index := -1
+	for i := range m.collections {
+		if m.collections[i].ID == id {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		m.mu.Unlock()
+		return fmt.Errorf("collection %s not found", id)
+	}
+	m.collections = append(m.collections[:index], m.collections[index+1:]...)
+	m.mu.Unlock()
+	err := m.saveToFile()
+	m.listeners.Notify()
+	return err
+}
+
+func (m *CollectionManager) GetCollection(id string) (Collection, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, collection := range m.collections {
+		if collection.ID == id {
+			return collection, true
+		}
+	}
+	return Collection{}, false
+}
+
+func (m *CollectionManager) ListCollections() []Collection {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]Collection{}, m.collections...)
+}
+
+// OnChange registers a callback invoked after every collection change.
+func (m *CollectionManager) OnChange(fn func()) {
+	m.listeners.Add(fn)
+}
+
+// withIDs fills in the missing IDs of the collection and of its requests,
+// reporting whether any was generated.
+func withIDs(collection Collection) (Collection, bool) {
+	generated := false
+	if collection.ID == "" {
+		collection.ID = newID()
+		generated = true
+	}
+	requests := make([]SavedRequest, 0, len(collection.Requests))
+	for _, request := range collection.Requests {
+		if request.ID == "" {
+			request.ID = newID()
+			generated = true
+		}
+		requests = append(requests, request)
+	}
+	collection.Requests = requests
+	return collection, generated
+}
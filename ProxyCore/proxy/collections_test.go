@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCollectionManagerPersistsGeneratedIDs(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "collections.json")
+	data := `[{"name": "api", "requests": [{"name": "users", "method": "GET", "url": "https://example.com/users"}]}]`
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	first := NewCollectionManager(file).ListCollections()
+	second := NewCollectionManager(file).ListCollections()
+	if len(first) != 1 || len(second) != 1 {
+		t.Fatalf("loaded %d and %d collections, want 1", len(first), len(second))
+	}
+	if first[0].ID == "" || first[0].ID != second[0].ID {
+		t.Fatalf("collection IDs %q and %q differ across loads", first[0].ID, second[0].ID)
+	}
+	if got, want := second[0].Requests[0].ID, first[0].Requests[0].ID; got == "" || got != want {
+		t.Fatalf("request IDs %q and %q differ across loads", want, got)
+	}
+}
+
+func TestCollectionManagerSaveCollectionMissingName(t *testing.T) {
+	m := NewCollectionManager(filepath.Join(t.TempDir(), "collections.json"))
+	if _, err := m.SaveCollection(Collection{}); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("SaveCollection() error = %v, want ErrInvalid", err)
+	}
+}
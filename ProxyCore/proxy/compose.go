@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"proxy_core/cert"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// KindComposed marks requests built in the composer rather than captured.
+const KindComposed = "composed"
+
+const defaultComposeTimeout = 30 * time.Second
+
+// ComposeRequest is a request built by hand and sent by the proxy itself.
+type ComposeRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	// BodyBase64 tells that Body is base64, for binary payloads
+	BodyBase64 bool `json:"body_base64,omitempty"`
+	// TimeoutMs covers the whole exchange, 30 seconds when zero
+	TimeoutMs       int  `json:"timeout_ms,omitempty"`
+	FollowRedirects bool `json:"follow_redirects,omitempty"`
+	// HTTPVersion is "1.1", "2" or empty to negotiate like proxied requests
+	HTTPVersion string `json:"http_version,omitempty"`
+}
+
+// Compose sends a composed request upstream, without going through the mock
+// rules, and records it as a KindComposed log entry. Errors are only
+// returned for invalid requests: network failures end up in the entry, as
+// does the request being canceled with ctx.
+func (p *ProxyServer) Compose(ctx context.Context, compose ComposeRequest) (RequestLog, error) {
+	method := strings.ToUpper(compose.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	u, err := url.Parse(compose.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return RequestLog{}, fmt.Errorf("invalid URL %q", compose.URL)
+	}
+	body := []byte(compose.Body)
+	if compose.BodyBase64 {
+		if body, err = base64.StdEncoding.DecodeString(compose.Body); err != nil {
+			return RequestLog{}, fmt.Errorf("invalid base64 body: %v", err)
+		}
+	}
+	transport, err := p.composeTransport(compose.HTTPVersion, u.Scheme)
+	if err != nil {
+		return RequestLog{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return RequestLog{}, err
+	}
+	for k, v := range compose.Headers {
+		if strings.EqualFold(k, "Host") {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+
+	timeout := defaultComposeTimeout
+	if compose.TimeoutMs > 0 {
+		timeout = time.Duration(compose.TimeoutMs) * time.Millisecond
+	}
+	client := &http.Client{Transport: transport, Timeout: timeout}
+	if !compose.FollowRedirects {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	logEntry := RequestLog{
+		Kind:            KindComposed,
+		Timestamp:       time.Now(),
+		Method:          method,
+		URL:             u.String(),
+		Protocol:        strings.ToUpper(u.Scheme),
+		RequestHeaders:  make(map[string]string),
+		ResponseHeaders: make(map[string]string),
+		UserAgent:       req.UserAgent(),
+	}
+	for k, v := range req.Header {
+		logEntry.RequestHeaders[k] = strings.Join(v, ", ")
+	}
+	requestCapture := newBodyCapture(p.maxCaptureSize())
+	requestCapture.Write(body)
+	logEntry.setRequestBody(requestCapture.result(req.Header.Get("Content-Encoding"), p.maxCaptureSize()), req.Header.Get("Content-Type"))
+
+	p.startLog(&logEntry)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		logEntry.Error = err.Error()
+		logEntry.Completed = time.Now()
+		logEntry.ResponseTime = logEntry.Completed.Sub(logEntry.Timestamp)
+		p.addLog(logEntry)
+		return logEntry, nil
+	}
+	defer resp.Body.Close()
+
+	logEntry.UpstreamProtocol = resp.Proto
+	if resp.TLS != nil {
+		logEntry.UpstreamTLS = upstreamTLSInfo(resp.TLS)
+		logEntry.UpstreamCertificates = cert.Summarize(resp.TLS.PeerCertificates)
+	}
+	for k, v := range resp.Header {
+		logEntry.ResponseHeaders[k] = strings.Join(v, ", ")
+	}
+	logEntry.StatusCode = resp.StatusCode
+
+	capture := newBodyCapture(p.maxCaptureSize())
+	if _, err := io.Copy(capture, resp.Body); err != nil {
+		logEntry.Error = err.Error()
+	}
+	logEntry.setResponseBody(capture.result(resp.Header.Get("Content-Encoding"), p.maxCaptureSize()), resp.Header.Get("Content-Type"))
+
+	logEntry.Completed = time.Now()
+	logEntry.ResponseTime = logEntry.Completed.Sub(logEntry.Timestamp)
+	p.addLog(logEntry)
+	return logEntry, nil
+}
+
+// composeTransport picks the transport for a forced HTTP version. The
+// dedicated ones are created on first use and then shared.
+func (p *ProxyServer) composeTransport(version, scheme string) (http.RoundTripper, error) {
+	switch version {
+	case "", "auto":
+		return p.transport, nil
+	case "1.1", "HTTP/1.1":
+		p.composeOnce.Do(p.initComposeTransports)
+		return p.http1Transport, nil
+	case "2", "2.0", "HTTP/2", "HTTP/2.0":
+		if scheme != "https" {
+			return nil, fmt.Errorf("HTTP/2 needs an https URL")
+		}
+		p.composeOnce.Do(p.initComposeTransports)
+		return p.http2Transport, nil
+	}
+	return nil, fmt.Errorf("unsupported HTTP version %q (1.1 or 2)", version)
+}
+
+func (p *ProxyServer) initComposeTransports() {
+	p.http1Transport = &http.Transport{
+		Proxy:              http.ProxyFromEnvironment,
+		TLSClientConfig:    &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"http/1.1"}},
+		DisableCompression: true,
+		// A non-nil empty map turns HTTP/2 off
+		TLSNextProto:    map[string]func(string, *tls.Conn) http.RoundTripper{},
+		IdleConnTimeout: 90 * time.Second,
+	}
+	p.http2Transport = &http2.Transport{
+		TLSClientConfig:    &tls.Config{InsecureSkipVerify: true},
+		DisableCompression: true,
+	}
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// bufferedConn is a connection whose first bytes were already peeked.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// singleConnListener hands one already established connection to an
+// http.Server and blocks further Accept calls until that connection is done.
+type singleConnListener struct {
+	conn net.Conn
+	once sync.Once
+	done chan struct{}
+}
+
+func (l *singleConnListener) Accept() (net.Conn, error) {
+	var conn net.Conn
+	l.once.Do(func() { conn = l.conn })
+	if conn != nil {
+		return conn, nil
+	}
+	<-l.done
+	return nil, net.ErrClosed
+}
+
+func (l *singleConnListener) Close() error {
+	return nil
+}
+
+func (l *singleConnListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// serveConn serves HTTP/1.x requests on conn until the client closes it, or
+// until the handler that hijacked it is done.
+func serveConn(conn net.Conn, handler http.Handler) {
+	listener := &singleConnListener{conn: conn, done: make(chan struct{})}
+	var closeOnce sync.Once
+	var active sync.WaitGroup
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			active.Add(1)
+			defer active.Done()
+			handler.ServeHTTP(w, r)
+		}),
+		ConnState: func(c net.Conn, state http.ConnState) {
+			if state == http.StateClosed || state == http.StateHijacked {
+				closeOnce.Do(func() { close(listener.done) })
+			}
+		},
+	}
+	server.Serve(listener)
+	active.Wait()
+}
+
+// nextProtos returns the ALPN protocols offered to intercepted clients.
+func (p *ProxyServer) nextProtos() []string {
+	if p.config.DisableHTTP2 {
+		return []string{"http/1.1"}
+	}
+	return []string{"h2", "http/1.1"}
+}
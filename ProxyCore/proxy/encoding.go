@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// bodyCapture records a request or response body as it goes through the proxy.
+// The bytes on the wire are never modified: the capture only keeps a bounded
+// copy and decodes it for display.
+type bodyCapture struct {
+	boundedBuffer
+	size int64 // bytes seen on the wire, including what was not kept
+}
+
+func newBodyCapture(limit int) *bodyCapture {
+	return &bodyCapture{boundedBuffer: boundedBuffer{limit: limit}}
+}
+
+func (c *bodyCapture) Write(data []byte) (int, error) {
+	c.size += int64(len(data))
+	return c.boundedBuffer.Write(data)
+}
+
+// teeBody captures a request body while it is read, so it can be streamed
+// upstream. The transport may still be reading it after RoundTrip returns.
+type teeBody struct {
+	body    io.ReadCloser
+	mu      sync.Mutex
+	capture *bodyCapture
+}
+
+func newTeeBody(body io.ReadCloser, limit int) *teeBody {
+	if body == nil {
+		body = http.NoBody
+	}
+	return &teeBody{body: body, capture: newBodyCapture(limit)}
+}
+
+func (t *teeBody) Read(b []byte) (int, error) {
+	n, err := t.body.Read(b)
+	t.mu.Lock()
+	t.capture.Write(b[:n])
+	t.mu.Unlock()
+	return n, err
+}
+
+func (t *teeBody) Close() error {
+	return t.body.Close()
+}
+
+// result returns what was read so far, see bodyCapture.result.
+func (t *teeBody) result(contentEncoding string, limit int) capturedBody {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.capture.result(contentEncoding, limit)
+}
+
+// capturedBody is what ends up in the log for a body.
+type capturedBody struct {
+	data        []byte
+	size        int64
+	decodedSize int64
+	truncated   bool
+}
+
+// result decodes the captured bytes according to the Content-Encoding header.
+// When decoding fails the raw bytes are shown instead. The decoded size is
+// left unknown (zero) in that case and when the capture was cut short.
+func (c *bodyCapture) result(contentEncoding string, limit int) capturedBody {
+	body := capturedBody{size: c.size, truncated: c.truncated}
+	if c.Len() == 0 {
+		return body
+	}
+
+	decoded, err := decodeContent(c.Bytes(), contentEncoding, limit)
+	switch {
+	case err != nil:
+		body.data = bytes.Clone(c.Bytes())
+	case c.truncated:
+		// Whatever decoded is only a prefix of the real content
+		body.data = decoded
+	default:
+		body.data = decoded
+		body.decodedSize = int64(len(decoded))
+		if len(decoded) > limit {
+			body.data = decoded[:limit]
+			body.truncated = true
+		}
+	}
+	return body
+}
+
+// decodeContent removes the content codings listed in a Content-Encoding
+// header, last applied first. At most limit+1 decoded bytes are produced, so
+// a small compressed body cannot blow up in memory.
+func decodeContent(data []byte, contentEncoding string, limit int) ([]byte, error) {
+	codings := strings.Split(contentEncoding, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		if coding == "" || coding == "identity" {
+			continue
+		}
+
+		reader, err := newDecoder(coding, data)
+		if err != nil {
+			return nil, err
+		}
+		decoded, err := io.ReadAll(io.LimitReader(reader, int64(limit)+1))
+		reader.Close()
+		// A body cut at the capture limit still yields a useful prefix
+		if err != nil && !(err == io.ErrUnexpectedEOF && len(decoded) > 0) {
+			return nil, fmt.Errorf("%s: %v", coding, err)
+		}
+		data = decoded
+	}
+	return data, nil
+}
+
+func newDecoder(coding string, data []byte) (io.ReadCloser, error) {
+	switch coding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(bytes.NewReader(data))
+	case "deflate":
+		// "deflate" should be zlib-wrapped but some servers send raw deflate
+		if reader, err := zlib.NewReader(bytes.NewReader(data)); err == nil {
+			return reader, nil
+		}
+		return flate.NewReader(bytes.NewReader(data)), nil
+	case "br":
+		return io.NopCloser(brotli.NewReader(bytes.NewReader(data))), nil
+	case "zstd":
+		decoder, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unsupported content encoding %q", coding)
+}
@@ -0,0 +1,56 @@
+package proxy
+
+// Event types pushed to subscribers.
+const (
+	// EventLogStarted and EventLogCompleted carry a RequestLog; both use the
+	// same ID so clients can update the in-flight entry
+	EventLogStarted   = "log_started"
+	EventLogCompleted = "log_completed"
+
+	EventMocksChanged = "mocks_changed"
+	EventAppsChanged  = "apps_changed"
+	EventCAChanged    = "ca_changed"
+
+	EventPassThroughChanged = "passthrough_changed"
+	EventCollectionsChanged = "collections_changed"
+	EventBreakpointsChanged = "breakpoints_changed"
+
+	// EventBreakpointPaused carries a PausedExchange waiting for the UI,
+	// EventBreakpointReleased tells it went on (or was aborted)
+	EventBreakpointPaused   = "breakpoint_paused"
+	EventBreakpointReleased = "breakpoint_released"
+)
+
+// Event is a notification sent over /ws, e.g. a request log or a rule update.
+// The "type" field tells clients what Data holds.
+type Event struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+func (p *ProxyServer) SubscribeEvents() chan Event {
+	ch := make(chan Event, 256)
+	p.mu.Lock()
+	p.eventClients[ch] = struct{}{}
+	p.mu.Unlock()
+	return ch
+}
+
+func (p *ProxyServer) UnsubscribeEvents(ch chan Event) {
+	p.mu.Lock()
+	delete(p.eventClients, ch)
+	p.mu.Unlock()
+	close(ch)
+}
+
+func (p *ProxyServer) notifyEvent(event Event) {
+	p.mu.RLock()
+	for ch := range p.eventClients {
+		select {
+		case ch <- event:
+		default:
+			// Skip if channel is full
+		}
+	}
+	p.mu.RUnlock()
+}
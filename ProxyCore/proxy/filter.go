@@ -0,0 +1,249 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+// LogFilter selects log entries. Zero fields match everything. The same filter
+// is used by /api/logs and by /ws subscriptions.
+type LogFilter struct {
+	// Host matches like mock rules: exact, "*.example.com" or with a port
+	Host      string
+	Path      string // substring of the path
+	PathRegex *regexp.Regexp
+	Methods   []string
+	StatusMin int
+	StatusMax int
+	App       string // app identifier, case-insensitive
+	Device    string // substring of the device info, case-insensitive
+	Mocked    *bool
+	// Kinds lists entry kinds, "request" standing for regular requests
+	Kinds []string
+	Since time.Time
+	Until time.Time
+	// Search looks for the text in the request and response bodies,
+	// case-insensitively
+	Search string
+}
+
+// ParseLogFilter reads a filter from query parameters: host, path, path_regex,
+// method (comma separated), status ("404", "4xx" or "200-299"), app, device,
+// mocked (true/false), kind (comma separated), since and until (RFC 3339)
+// and q.
+func ParseLogFilter(query url.Values) (LogFilter, error) {
+	filter := LogFilter{
+		Host:   query.Get("host"),
+		Path:   query.Get("path"),
+		App:    query.Get("app"),
+		Device: strings.ToLower(query.Get("device")),
+		Search: strings.ToLower(query.Get("q")),
+	}
+
+	if expr := query.Get("path_regex"); expr != "" {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return filter, fmt.Errorf("invalid path_regex: %v", err)
+		}
+		filter.PathRegex = re
+	}
+	if methods := query.Get("method"); methods != "" {
+		for _, method := range strings.Split(methods, ",") {
+			filter.Methods = append(filter.Methods, strings.ToUpper(strings.TrimSpace(method)))
+		}
+	}
+	if status := query.Get("status"); status != "" {
+		min, max, err := parseStatusRange(status)
+		if err != nil {
+			return filter, err
+		}
+		filter.StatusMin, filter.StatusMax = min, max
+	}
+	if mocked := query.Get("mocked"); mocked != "" {
+		value, err := strconv.ParseBool(mocked)
+		if err != nil {
+			return filter, fmt.Errorf("invalid mocked: %v", err)
+		}
+		filter.Mocked = &value
+	}
+	if kinds := query.Get("kind"); kinds != "" {
+		for _, kind := range strings.Split(kinds, ",") {
+			filter.Kinds = append(filter.Kinds, strings.ToLower(strings.TrimSpace(kind)))
+		}
+	}
+	for name, target := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s: %v", name, err)
+			}
+			*target = t
+		}
+	}
+	return filter, nil
+}
+
+func parseStatusRange(status string) (int, int, error) {
+	if len(status) == 3 && strings.HasSuffix(strings.ToLower(status), "xx") {
+		class, err := strconv.Atoi(status[:1])
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid status %q", status)
+		}
+		return class * 100, class*100 + 99, nil
+	}
+	from, to, isRange := strings.Cut(status, "-")
+	min, err := strconv.Atoi(from)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid status %q", status)
+	}
+	if !isRange {
+		return min, min, nil
+	}
+	max, err := strconv.Atoi(to)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid status %q", status)
+	}
+	return min, max, nil
+}
+
+// Match reports whether the entry passes the filter.
+func (f LogFilter) Match(entry RequestLog) bool {
+	u, _ := url.Parse(entry.URL)
+	if u == nil {
+		u = &url.URL{}
+	}
+
+	if f.Host != "" {
+		if _, ok := matchHostPattern(f.Host, u.Host); !ok {
+			return false
+		}
+	}
+	if f.Path != "" && !strings.Contains(u.Path, f.Path) {
+		return false
+	}
+	if f.PathRegex != nil && !f.PathRegex.MatchString(u.Path) {
+		return false
+	}
+	if len(f.Methods) > 0 && !containsString(f.Methods, entry.Method) {
+		return false
+	}
+	if f.StatusMin > 0 && entry.StatusCode < f.StatusMin {
+		return false
+	}
+	if f.StatusMax > 0 && entry.StatusCode > f.StatusMax {
+		return false
+	}
+	if f.App != "" && !strings.EqualFold(f.App, entry.AppIdentifier) {
+		return false
+	}
+	if len(f.Kinds) > 0 {
+		kind := entry.Kind
+		if kind == "" {
+			kind = "request"
+		}
+		if !containsString(f.Kinds, kind) {
+			return false
+		}
+	}
+	if f.Device != "" && !strings.Contains(strings.ToLower(entry.DeviceInfo), f.Device) {
+		return false
+	}
+	if f.Mocked != nil && *f.Mocked != entry.Mocked {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Timestamp.After(f.Until) {
+		return false
+	}
+	if f.Search != "" {
+		search := []byte(f.Search)
+		if !bytes.Contains(bytes.ToLower(entry.RawRequestBody), search) &&
+			!bytes.Contains(bytes.ToLower(entry.RawResponseBody), search) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// LogQuery is a page request over stored logs. Cursor is the ID of the last
+// entry of the previous page, zero for the first page.
+type LogQuery struct {
+	Filter    LogFilter
+	Cursor    uint64
+	Limit     int
+	Ascending bool
+}
+
+// Normalized returns the query with the limit brought within bounds.
+func (q LogQuery) Normalized() LogQuery {
+	if q.Limit <= 0 {
+		q.Limit = defaultQueryLimit
+	}
+	if q.Limit > maxQueryLimit {
+		q.Limit = maxQueryLimit
+	}
+	return q
+}
+
+// AfterCursor reports whether an entry with the given ID belongs to the pages
+// following the cursor.
+func (q LogQuery) AfterCursor(id uint64) bool {
+	if q.Cursor == 0 {
+		return true
+	}
+	if q.Ascending {
+		return id > q.Cursor
+	}
+	return id < q.Cursor
+}
+
+// QueryLogs returns a page of matching entries from the in-memory log, newest
+// first unless Ascending is set, and the cursor for the next page (zero when
+// there is none).
+func (p *ProxyServer) QueryLogs(query LogQuery) ([]RequestLog, uint64) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return query.Apply(p.logs)
+}
+
+// Apply pages through logs, which must be sorted by ID.
+func (q LogQuery) Apply(logs []RequestLog) ([]RequestLog, uint64) {
+	q = q.Normalized()
+	page := []RequestLog{}
+	for i := range logs {
+		index := i
+		if !q.Ascending {
+			index = len(logs) - 1 - i
+		}
+		entry := logs[index]
+		if !q.AfterCursor(entry.ID) || !q.Filter.Match(entry) {
+			continue
+		}
+		if len(page) == q.Limit {
+			return page, page[len(page)-1].ID
+		}
+		page = append(page, entry)
+	}
+	return page, 0
+}
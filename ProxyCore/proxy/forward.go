@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"proxy_core/cert"
+	"strings"
+	"time"
+)
+
+// Hop-by-hop headers are meaningful only for a single connection and must not
+// be forwarded (RFC 9110, section 7.6.1). HTTP/2 rejects most of them outright.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func removeHopHeaders(header http.Header) {
+	for _, field := range strings.Split(header.Get("Connection"), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			header.Del(field)
+		}
+	}
+	for _, h := range hopHeaders {
+		header.Del(h)
+	}
+}
+
+// acceptsTrailers reports whether the client sent "Te: trailers", the only Te
+// value HTTP/2 allows. gRPC servers refuse requests without it.
+func acceptsTrailers(header http.Header) bool {
+	for _, value := range header.Values("Te") {
+		for _, token := range strings.Split(value, ",") {
+			token, _, _ = strings.Cut(token, ";")
+			if strings.EqualFold(strings.TrimSpace(token), "trailers") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// forward runs a request through the mock rules and, when none applies, sends
+// it upstream and relays the response to w. Plain HTTP and intercepted HTTPS
+// requests (HTTP/1.1 or HTTP/2) all go through here. req.URL must be absolute
+// and host is the host the client asked for, used for mock matching. The
+// finished log entry is returned.
+func (p *ProxyServer) forward(w http.ResponseWriter, req *http.Request, host string, logEntry RequestLog) RequestLog {
+	for k, v := range req.Header {
+		logEntry.RequestHeaders[k] = strings.Join(v, ", ")
+	}
+
+	// The request body is streamed upstream, only a bounded copy is kept
+	requestBody := newTeeBody(req.Body, p.maxCaptureSize())
+	requestEncoding, requestType := req.Header.Get("Content-Encoding"), req.Header.Get("Content-Type")
+	logRequestBody := func() {
+		logEntry.setRequestBody(requestBody.result(requestEncoding, p.maxCaptureSize()), requestType)
+	}
+
+	p.startLog(&logEntry)
+
+	var outBody io.ReadCloser = requestBody
+	outLength := req.ContentLength
+	if rule, ok := p.breakpoints.Match(StageRequest, req.Method, host, req.URL.Path); ok {
+		// A paused request is shown and edited whole
+		body, _ := io.ReadAll(requestBody)
+		logRequestBody()
+		var aborted bool
+		if body, host, aborted = p.breakOnRequest(req, body, host, rule, &logEntry); aborted {
+			return p.abortAtBreakpoint(w, logEntry)
+		}
+		outBody, outLength = io.NopCloser(bytes.NewReader(body)), int64(len(body))
+		// breakOnRequest has logged the body actually sent
+		logRequestBody = func() {}
+	}
+
+	// 🔥 MATCH MOCK PRIMA DI INOLTRARE LA RICHIESTA
+	if mockResp := p.matchMock(req, host, &logEntry); mockResp != nil {
+		io.Copy(io.Discard, requestBody)
+		logRequestBody()
+		for k, v := range mockResp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(mockResp.StatusCode)
+		io.Copy(w, mockResp.Body)
+		p.addLog(logEntry)
+		return logEntry
+	}
+
+	if isWebSocketUpgrade(req) {
+		p.handleWebSocket(w, req, logEntry)
+		return logEntry
+	}
+
+	outReq := req.Clone(req.Context())
+	outReq.RequestURI = ""
+	outReq.Body = outBody
+	outReq.ContentLength = outLength
+	if outLength == 0 {
+		outReq.Body = nil
+	}
+	// Request trailers are only known once the body has been read
+	outReq.Trailer = req.Trailer
+	removeHopHeaders(outReq.Header)
+	if acceptsTrailers(req.Header) {
+		outReq.Header.Set("Te", "trailers")
+	}
+
+	resp, err := p.transport.RoundTrip(outReq)
+	if err != nil {
+		log.Printf("Error forwarding %s: %v", logEntry.URL, err)
+		logEntry.StatusCode = http.StatusBadGateway
+		logEntry.Error = err.Error()
+		logEntry.Completed = time.Now()
+		logEntry.ResponseTime = logEntry.Completed.Sub(logEntry.Timestamp)
+		logRequestBody()
+		p.addLog(logEntry)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return logEntry
+	}
+	defer resp.Body.Close()
+
+	logEntry.UpstreamProtocol = resp.Proto
+	if resp.TLS != nil {
+		logEntry.UpstreamTLS = upstreamTLSInfo(resp.TLS)
+		if len(resp.TLS.PeerCertificates) > 0 {
+			p.certManager.RecordUpstreamCertificate(host, resp.TLS.PeerCertificates[0])
+			if logEntry.UpstreamCertificates == nil {
+				logEntry.UpstreamCertificates = cert.Summarize(resp.TLS.PeerCertificates)
+			}
+		}
+	}
+
+	// Event streams never end, there is nothing to pause on
+	if rule, ok := p.breakpoints.Match(StageResponse, req.Method, host, req.URL.Path); ok && !isEventStream(resp.Header) {
+		if p.breakOnResponse(req, resp, rule, &logEntry) {
+			logRequestBody()
+			return p.abortAtBreakpoint(w, logEntry)
+		}
+	}
+
+	// Copy response headers
+	removeHopHeaders(resp.Header)
+	for k, v := range resp.Header {
+		logEntry.ResponseHeaders[k] = strings.Join(v, ", ")
+		w.Header()[k] = v
+	}
+	// Trailers have to be announced before the header is written
+	for k := range resp.Trailer {
+		w.Header().Add("Trailer", k)
+	}
+
+	// Set status code
+	w.WriteHeader(resp.StatusCode)
+	logEntry.StatusCode = resp.StatusCode
+
+	// Relay the body as it arrives, keeping a bounded copy for the log
+	capture := newBodyCapture(p.maxCaptureSize())
+	contentEncoding := resp.Header.Get("Content-Encoding")
+	var observe func([]byte)
+	if isEventStream(resp.Header) && (contentEncoding == "" || contentEncoding == "identity") {
+		parser := newSSEParser(func(event SSEEvent) {
+			if len(logEntry.SSEEvents) < maxSSEEvents {
+				logEntry.SSEEvents = append(logEntry.SSEEvents, event)
+			}
+			p.notifyEvent(Event{Type: EventSSE, Data: sseMessage{ID: logEntry.ID, URL: logEntry.URL, Event: event}})
+		})
+		observe = parser.Write
+	}
+	logEntry.Streamed = isStreamingResponse(resp)
+	if err := relayBody(w, resp.Body, capture, logEntry.Streamed, observe); err != nil {
+		log.Printf("Error relaying response from %s: %v", logEntry.URL, err)
+	}
+	// resp.Trailer is filled once the body has been read to the end
+	for k, v := range resp.Trailer {
+		w.Header()[k] = v
+	}
+	logEntry.setResponseBody(capture.result(contentEncoding, p.maxCaptureSize()), resp.Header.Get("Content-Type"))
+
+	// Complete the log
+	logRequestBody()
+	logEntry.Completed = time.Now()
+	logEntry.ResponseTime = logEntry.Completed.Sub(logEntry.Timestamp)
+	p.addLog(logEntry)
+	return logEntry
+}
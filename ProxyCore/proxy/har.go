@@ -0,0 +1,308 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HAR 1.2 (http://www.softwareishard.com/blog/har-12-spec/). Fields that
+// PacketPeek records but the spec has no place for use the "_" prefix
+// reserved for custom fields.
+
+const harVersion = "1.2"
+
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+
+	ID            uint64 `json:"_id,omitempty"`
+	Kind          string `json:"_kind,omitempty"`
+	Mocked        bool   `json:"_mocked,omitempty"`
+	Streamed      bool   `json:"_streamed,omitempty"`
+	ClientIP      string `json:"_clientIP,omitempty"`
+	AppIdentifier string `json:"_appIdentifier,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	// postData has no encoding field in the spec, binary bodies are marked here
+	Encoding string `json:"_encoding,omitempty"`
+}
+
+type HARContent struct {
+	Size        int64  `json:"size"`
+	Compression int64  `json:"compression,omitempty"`
+	MimeType    string `json:"mimeType"`
+	Text        string `json:"text,omitempty"`
+	Encoding    string `json:"encoding,omitempty"`
+}
+
+// HARTimings only has the total wait: the proxy does not time the phases of
+// a request separately. -1 means not available.
+type HARTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// ToHAR converts log entries to a HAR document, oldest first.
+func ToHAR(logs []RequestLog) HAR {
+	har := HAR{Log: HARLog{
+		Version: harVersion,
+		Creator: HARCreator{Name: "PacketPeek", Version: "1.0"},
+		Entries: make([]HAREntry, 0, len(logs)),
+	}}
+	for _, entry := range logs {
+		har.Log.Entries = append(har.Log.Entries, harEntry(entry))
+	}
+	sort.SliceStable(har.Log.Entries, func(i, j int) bool {
+		return har.Log.Entries[i].StartedDateTime.Before(har.Log.Entries[j].StartedDateTime)
+	})
+	return har
+}
+
+func harEntry(entry RequestLog) HAREntry {
+	total := float64(entry.ResponseTime) / float64(time.Millisecond)
+	version := entry.ClientProtocol
+	if version == "" {
+		version = entry.Protocol
+	}
+
+	request := HARRequest{
+		Method:      entry.Method,
+		URL:         entry.URL,
+		HTTPVersion: version,
+		Cookies:     []HARCookie{},
+		Headers:     harHeaders(entry.RequestHeaders),
+		QueryString: []HARNameValue{},
+		HeadersSize: -1,
+		BodySize:    entry.RequestBodySize,
+	}
+	if u, err := url.Parse(entry.URL); err == nil {
+		for name, values := range u.Query() {
+			for _, value := range values {
+				request.QueryString = append(request.QueryString, HARNameValue{Name: name, Value: value})
+			}
+		}
+		sort.Slice(request.QueryString, func(i, j int) bool {
+			return request.QueryString[i].Name < request.QueryString[j].Name
+		})
+	}
+	if cookie := entry.RequestHeaders["Cookie"]; cookie != "" {
+		for _, c := range (&http.Request{Header: http.Header{"Cookie": {cookie}}}).Cookies() {
+			request.Cookies = append(request.Cookies, HARCookie{Name: c.Name, Value: c.Value})
+		}
+	}
+	if len(entry.RawRequestBody) > 0 {
+		request.PostData = &HARPostData{MimeType: contentType(entry.RequestHeaders, entry.RequestMIMEType)}
+		request.PostData.Text, request.PostData.Encoding = harText(entry.RawRequestBody)
+	}
+
+	response := HARResponse{
+		Status:      entry.StatusCode,
+		StatusText:  http.StatusText(entry.StatusCode),
+		HTTPVersion: version,
+		// Set-Cookie values are merged in the log and cannot be split reliably
+		Cookies:     []HARCookie{},
+		Headers:     harHeaders(entry.ResponseHeaders),
+		RedirectURL: entry.ResponseHeaders["Location"],
+		HeadersSize: -1,
+		BodySize:    entry.ResponseBodySize,
+		Content: HARContent{
+			Size:     int64(len(entry.RawResponseBody)),
+			MimeType: contentType(entry.ResponseHeaders, entry.ResponseMIMEType),
+		},
+	}
+	if entry.ResponseBodyDecodedSize > 0 {
+		response.Content.Size = entry.ResponseBodyDecodedSize
+		response.Content.Compression = entry.ResponseBodyDecodedSize - entry.ResponseBodySize
+	}
+	response.Content.Text, response.Content.Encoding = harText(entry.RawResponseBody)
+
+	return HAREntry{
+		StartedDateTime: entry.Timestamp,
+		Time:            total,
+		Request:         request,
+		Response:        response,
+		Timings:         HARTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1, Wait: total},
+		ID:              entry.ID,
+		Kind:            entry.Kind,
+		Mocked:          entry.Mocked,
+		Streamed:        entry.Streamed,
+		ClientIP:        entry.ClientIP,
+		AppIdentifier:   entry.AppIdentifier,
+	}
+}
+
+// FromHAR converts HAR entries to log entries numbered from 1 in file order.
+func FromHAR(har HAR) ([]RequestLog, error) {
+	logs := make([]RequestLog, 0, len(har.Log.Entries))
+	for i, entry := range har.Log.Entries {
+		if entry.Request.Method == "" || entry.Request.URL == "" {
+			return nil, fmt.Errorf("entry %d: missing request method or URL", i)
+		}
+
+		logEntry := RequestLog{
+			ID:              uint64(i + 1),
+			Kind:            entry.Kind,
+			Method:          entry.Request.Method,
+			URL:             entry.Request.URL,
+			Protocol:        entry.Request.HTTPVersion,
+			ClientProtocol:  entry.Request.HTTPVersion,
+			ClientIP:        entry.ClientIP,
+			RequestHeaders:  logHeaders(entry.Request.Headers),
+			StatusCode:      entry.Response.Status,
+			ResponseHeaders: logHeaders(entry.Response.Headers),
+			ResponseTime:    time.Duration(entry.Time * float64(time.Millisecond)),
+			Mocked:          entry.Mocked,
+			Streamed:        entry.Streamed,
+			Timestamp:       entry.StartedDateTime,
+			AppIdentifier:   entry.AppIdentifier,
+		}
+		logEntry.Completed = logEntry.Timestamp.Add(logEntry.ResponseTime)
+
+		if postData := entry.Request.PostData; postData != nil {
+			data, err := harBytes(postData.Text, postData.Encoding)
+			if err != nil {
+				return nil, fmt.Errorf("entry %d: request body: %v", i, err)
+			}
+			logEntry.setRequestBody(capturedBody{
+				data:        data,
+				size:        harSize(entry.Request.BodySize, data),
+				decodedSize: int64(len(data)),
+			}, postData.MimeType)
+		}
+
+		content := entry.Response.Content
+		data, err := harBytes(content.Text, content.Encoding)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: response body: %v", i, err)
+		}
+		body := capturedBody{data: data, size: harSize(entry.Response.BodySize, data), decodedSize: content.Size}
+		// Browsers often leave the text out for large or binary content
+		body.truncated = content.Size > int64(len(data))
+		logEntry.setResponseBody(body, content.MimeType)
+
+		logs = append(logs, logEntry)
+	}
+	return logs, nil
+}
+
+func harHeaders(headers map[string]string) []HARNameValue {
+	list := make([]HARNameValue, 0, len(headers))
+	for name, value := range headers {
+		list = append(list, HARNameValue{Name: name, Value: value})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// logHeaders merges repeated headers the same way captured ones are.
+func logHeaders(headers []HARNameValue) map[string]string {
+	merged := make(http.Header)
+	for _, header := range headers {
+		// HTTP/2 pseudo-headers are already part of the request line
+		if strings.HasPrefix(header.Name, ":") {
+			continue
+		}
+		merged.Add(header.Name, header.Value)
+	}
+	result := make(map[string]string, len(merged))
+	for k, v := range merged {
+		result[k] = strings.Join(v, ", ")
+	}
+	return result
+}
+
+func contentType(headers map[string]string, mimeType string) string {
+	if value := headers["Content-Type"]; value != "" {
+		return value
+	}
+	return mimeType
+}
+
+func harText(data []byte) (string, string) {
+	text, isBase64 := bodyText(data)
+	if isBase64 {
+		return text, "base64"
+	}
+	return text, ""
+}
+
+func harBytes(text, encoding string) ([]byte, error) {
+	if encoding == "base64" {
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return []byte(text), nil
+}
+
+// harSize reads a HAR size field, where -1 means unknown.
+func harSize(size int64, data []byte) int64 {
+	if size < 0 {
+		return int64(len(data))
+	}
+	return size
+}
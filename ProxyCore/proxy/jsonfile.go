@@ -0,0 +1,27 @@
+package proxy
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// The managers keep their records in JSON files next to the binary, so they
+// can be shared or edited by hand.
+
+// loadJSONFile decodes the file at path into v.
+func loadJSONFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// saveJSONFile replaces the file at path with v, indented.
+func saveJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
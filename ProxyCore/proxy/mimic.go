@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"proxy_core/cert"
+	"time"
+)
+
+// mimicCertificate returns a leaf copying the certificate of the origin behind
+// host. The origin is only contacted when no mimicked leaf is cached for it, in
+// which case the summary of the upstream chain is returned too; otherwise the
+// chain is filled in by the first forwarded request.
+func (p *ProxyServer) mimicCertificate(host string, hello *tls.ClientHelloInfo) (*tls.Certificate, []cert.CertificateSummary, error) {
+	serverName := hello.ServerName
+	if h, _, err := net.SplitHostPort(host); err != nil {
+		if serverName == "" {
+			serverName = host
+		}
+		host = net.JoinHostPort(host, "443")
+	} else if serverName == "" {
+		serverName = h
+	}
+
+	var chain []cert.CertificateSummary
+	leaf, err := p.certManager.GetMimicCertificate(hello, serverName+"@"+host, func() (*x509.Certificate, error) {
+		dialer := &net.Dialer{Timeout: 10 * time.Second}
+		conn, err := tls.DialWithDialer(dialer, "tcp", host, &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		peers := conn.ConnectionState().PeerCertificates
+		conn.Close()
+		if len(peers) == 0 {
+			return nil, fmt.Errorf("upstream presented no certificate")
+		}
+		p.certManager.RecordUpstreamCertificate(host, peers[0])
+		chain = cert.Summarize(peers)
+		return peers[0], nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return leaf, chain, nil
+}
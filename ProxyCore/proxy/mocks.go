@@ -0,0 +1,276 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"proxy_core/internal/notify"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrInvalid is wrapped by the errors the managers return for records that
+// fail validation, as opposed to records that could not be saved.
+var ErrInvalid = errors.New("invalid")
+
+type MockResponse struct {
+	ID          string `json:"id"`
+	Method      string `json:"method"`
+	Host        string `json:"host"`
+	Path        string `json:"path"`
+	IsRegex     bool   `json:"is_regex"`
+	StatusCode  int    `json:"status_code"`
+	LatencyMs   int    `json:"latency_ms"`
+	Response    string `json:"response"`
+	ContentType string `json:"content_type"`
+	IsActive    bool   `json:"is_active"`
+}
+
+type MockManager struct {
+	mocks     []MockResponse
+	regexes   map[string]*regexp.Regexp
+	mu        sync.RWMutex
+	file      string
+	listeners notify.Listeners
+}
+
+func NewMockManager(configFile string) *MockManager {
+	manager := &MockManager{
+		regexes: make(map[string]*regexp.Regexp),
+		file:    configFile,
+	}
+	manager.loadFromFile()
+	return manager
+}
+
+func (m *MockManager) loadFromFile() error {
+	var mocks []MockResponse
+	if err := loadJSONFile(m.file, &mocks); err != nil {
+		return err
+	}
+
+	generated := false
+	m.mu.Lock()
+	for _, mock := range mocks {
+		if mock.ID == "" {
+			mock.ID = newID()
+			generated = true
+		}
+		if mock.IsRegex {
+			// Rules with an invalid regex are kept so they can be fixed from
+			// the UI; without a compiled regex they never match
+			if re, err := regexp.Compile(mock.Path); err == nil {
+				m.regexes[mock.ID] = re
+			}
+		}
+		m.mocks = append(m.mocks, mock)
+	}
+	m.mu.Unlock()
+
+	// Keep the generated IDs stable across restarts
+	if generated {
+		return m.saveToFile()
+	}
+	return nil
+}
+
+func (m *MockManager) saveToFile() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return saveJSONFile(m.file, m.mocks)
+}
+
+// AddMock inserts a new rule or replaces the one with the same ID.
+func (m *MockManager) AddMock(mock MockResponse) error {
+	var re *regexp.Regexp
+	if mock.IsRegex {
+		var err error
+		if re, err = regexp.Compile(mock.Path); err != nil {
+			return fmt.Errorf("%w path regex: %v", ErrInvalid, err)
+		}
+	}
+	if mock.StatusCode == 0 {
+		mock.StatusCode = 200
+	}
+
+	m.mu.Lock()
+	if mock.ID == "" {
+		mock.ID = newID()
+	}
+	replaced := false
+	for i := range m.mocks {
+		if m.mocks[i].ID == mock.ID {
+			m.mocks[i] = mock
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		m.mocks = append(m.mocks, mock)
+	}
+	delete(m.regexes, mock.ID)
+	if re != nil {
+		m.regexes[mock.ID] = re
+	}
+	m.mu.Unlock()
+	err := m.saveToFile()
+	m.listeners.Notify()
+	return err
+}
+
+func (m *MockManager) DeleteMockByID(id string) error {
+	m.mu.Lock()
+	index := -1
+	for i := range m.mocks {
+		if m.mocks[i].ID == id {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		m.mu.Unlock()
+		return fmt.Errorf("mock %s not found", id)
+	}
+	m.mocks = append(m.mocks[:index], m.mocks[index+1:]...)
+	delete(m.regexes, id)
+	m.mu.Unlock()
+	err := m.saveToFile()
+	m.listeners.Notify()
+	return err
+}
+
+// OnChange registers a callback invoked after every rule change.
+func (m *MockManager) OnChange(fn func()) {
+	m.listeners.Add(fn)
+}
+
+func (m *MockManager) GetMock(id string) (MockResponse, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, mock := range m.mocks {
+		if mock.ID == id {
+			return mock, true
+		}
+	}
+	return MockResponse{}, false
+}
+
+func (m *MockManager) ListMocks() []MockResponse {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]MockResponse{}, m.mocks...)
+}
+
+// Match returns the active rule that best fits the request. When several rules
+// overlap the most specific one wins: exact host over wildcard host over any
+// host, then literal path over regex path, then explicit method over any method,
+// then the longer path pattern, then the rule that was added first.
+func (m *MockManager) Match(method, host, path string) (*MockResponse, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	type candidate struct {
+		mock  MockResponse
+		score int
+		index int
+	}
+	var candidates []candidate
+
+	for i, mock := range m.mocks {
+		if !mock.IsActive {
+			continue
+		}
+
+		methodScore, ok := matchMockMethod(mock.Method, method)
+		if !ok {
+			continue
+		}
+		hostScore, ok := matchHostPattern(mock.Host, host)
+		if !ok {
+			continue
+		}
+		pathScore, ok := m.matchMockPath(mock, path)
+		if !ok {
+			continue
+		}
+
+		candidates = append(candidates, candidate{
+			mock:  mock,
+			score: hostScore*100 + pathScore*10 + methodScore,
+			index: i,
+		})
+	}
+
+	if len(candidates) == 0 {
+		return nil, false
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if len(a.mock.Path) != len(b.mock.Path) {
+			return len(a.mock.Path) > len(b.mock.Path)
+		}
+		return a.index < b.index
+	})
+
+	best := candidates[0].mock
+	return &best, true
+}
+
+func matchMockMethod(pattern, method string) (int, bool) {
+	if pattern == "" || pattern == "*" {
+		return 0, true
+	}
+	return 1, strings.EqualFold(pattern, method)
+}
+
+// matchHostPattern compares hosts case-insensitively. The port is only taken into
+// account when the rule specifies one, and "*.example.com" matches any subdomain.
+func matchHostPattern(pattern, host string) (int, bool) {
+	if pattern == "" || pattern == "*" {
+		return 0, true
+	}
+	pattern = strings.ToLower(pattern)
+	host = strings.ToLower(host)
+
+	if _, _, err := net.SplitHostPort(pattern); err != nil {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	} else if _, _, err := net.SplitHostPort(host); err != nil {
+		return 0, false
+	}
+
+	if strings.HasPrefix(pattern, "*.") {
+		return 1, strings.HasSuffix(host, pattern[1:])
+	}
+	return 2, pattern == host
+}
+
+func (m *MockManager) matchMockPath(mock MockResponse, path string) (int, bool) {
+	if mock.IsRegex {
+		re, ok := m.regexes[mock.ID]
+		if !ok {
+			return 0, false
+		}
+		return 0, re.MatchString(path)
+	}
+	if mock.Path == "" || mock.Path == "*" {
+		return 0, true
+	}
+	return 1, mock.Path == path
+}
+
+// newID returns a random hex identifier for mocks and other records.
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
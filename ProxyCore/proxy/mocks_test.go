@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func newTestMockManager(t *testing.T, mocks ...MockResponse) *MockManager {
+	t.Helper()
+	m := NewMockManager(filepath.Join(t.TempDir(), "mocks.json"))
+	for _, mock := range mocks {
+		if err := m.AddMock(mock); err != nil {
+			t.Fatalf("AddMock(%+v): %v", mock, err)
+		}
+	}
+	return m
+}
+
+func TestMockManagerMatchPrecedence(t *testing.T) {
+	tests := []struct {
+		name   string
+		mocks  []MockResponse
+		method string
+		host   string
+		path   string
+		want   string // ID of the expected rule, empty for no match
+	}{
+		{
+			name: "exact host beats wildcard host",
+			mocks: []MockResponse{
+				{ID: "wildcard", Host: "*.example.com", Path: "/users", IsActive: true},
+				{ID: "exact", Host: "api.example.com", Path: "/users", IsActive: true},
+			},
+			method: "GET", host: "api.example.com", path: "/users",
+			want: "exact",
+		},
+		{
+			name: "wildcard host beats any host",
+			mocks: []MockResponse{
+				{ID: "any", Path: "/users", IsActive: true},
+				{ID: "wildcard", Host: "*.example.com", Path: "/users", IsActive: true},
+			},
+			method: "GET", host: "api.example.com", path: "/users",
+			want: "wildcard",
+		},
+		{
+			name: "host beats path",
+			mocks: []MockResponse{
+				{ID: "literal-path", Path: "/users", IsActive: true},
+				{ID: "host", Host: "api.example.com", Path: "/.*", IsRegex: true, IsActive: true},
+			},
+			method: "GET", host: "api.example.com", path: "/users",
+			want: "host",
+		},
+		{
+			name: "literal path beats regex path",
+			mocks: []MockResponse{
+				{ID: "regex", Path: "^/users$", IsRegex: true, IsActive: true},
+				{ID: "literal", Path: "/users", IsActive: true},
+			},
+			method: "GET", host: "api.example.com", path: "/users",
+			want: "literal",
+		},
+		{
+			name: "path beats method",
+			mocks: []MockResponse{
+				{ID: "method", Method: "GET", Path: "/u.*", IsRegex: true, IsActive: true},
+				{ID: "literal-path", Path: "/users", IsActive: true},
+			},
+			method: "GET", host: "api.example.com", path: "/users",
+			want: "literal-path",
+		},
+		{
+			name: "explicit method beats any method",
+			mocks: []MockResponse{
+				{ID: "any", Method: "*", Path: "/users", IsActive: true},
+				{ID: "get", Method: "get", Path: "/users", IsActive: true},
+			},
+			method: "GET", host: "api.example.com", path: "/users",
+			want: "get",
+		},
+		{
+			name: "longer path pattern wins on equal score",
+			mocks: []MockResponse{
+				{ID: "short", Path: "/u.*", IsRegex: true, IsActive: true},
+				{ID: "long", Path: "/users/.*", IsRegex: true, IsActive: true},
+			},
+			method: "GET", host: "api.example.com", path: "/users/42",
+			want: "long",
+		},
+		{
+			name: "first added rule wins on a full tie",
+			mocks: []MockResponse{
+				{ID: "first", Path: "/users", IsActive: true},
+				{ID: "second", Path: "/users", IsActive: true},
+			},
+			method: "GET", host: "api.example.com", path: "/users",
+			want: "first",
+		},
+		{
+			name: "inactive rules are skipped",
+			mocks: []MockResponse{
+				{ID: "inactive", Host: "api.example.com", Path: "/users", IsActive: false},
+				{ID: "active", Path: "/users", IsActive: true},
+			},
+			method: "GET", host: "api.example.com", path: "/users",
+			want: "active",
+		},
+		{
+			name: "host without port in the rule ignores the request port",
+			mocks: []MockResponse{
+				{ID: "host", Host: "API.example.com", Path: "/users", IsActive: true},
+			},
+			method: "GET", host: "api.example.com:8443", path: "/users",
+			want: "host",
+		},
+		{
+			name: "host with port in the rule needs the same port",
+			mocks: []MockResponse{
+				{ID: "port", Host: "api.example.com:8443", Path: "/users", IsActive: true},
+			},
+			method: "GET", host: "api.example.com", path: "/users",
+			want: "",
+		},
+		{
+			name: "wildcard host does not match the bare domain",
+			mocks: []MockResponse{
+				{ID: "wildcard", Host: "*.example.com", IsActive: true},
+			},
+			method: "GET", host: "example.com", path: "/",
+			want: "",
+		},
+		{
+			name: "method mismatch",
+			mocks: []MockResponse{
+				{ID: "post", Method: "POST", Path: "/users", IsActive: true},
+			},
+			method: "GET", host: "api.example.com", path: "/users",
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMockManager(t, tt.mocks...)
+			got, ok := m.Match(tt.method, tt.host, tt.path)
+			switch {
+			case tt.want == "" && ok:
+				t.Fatalf("Match() = %q, want no match", got.ID)
+			case tt.want != "" && !ok:
+				t.Fatalf("Match() found nothing, want %q", tt.want)
+			case ok && got.ID != tt.want:
+				t.Fatalf("Match() = %q, want %q", got.ID, tt.want)
+			}
+		})
+	}
+}
+
+func TestMockManagerAddMockInvalidRegex(t *testing.T) {
+	m := newTestMockManager(t)
+	err := m.AddMock(MockResponse{Path: "(", IsRegex: true})
+	if !errors.Is(err, ErrInvalid) {
+		t.Fatalf("AddMock() error = %v, want ErrInvalid", err)
+	}
+	if mocks := m.ListMocks(); len(mocks) != 0 {
+		t.Fatalf("invalid rule was added: %+v", mocks)
+	}
+}
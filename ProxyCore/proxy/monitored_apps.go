@@ -1,21 +1,25 @@
 package proxy
 
 import (
-	"encoding/json"
-	"io/ioutil"
+	"proxy_core/internal/notify"
+	"strings"
 	"sync"
 )
 
 type MonitoredApp struct {
-	BundleID      string `json:"bundle_id"`
-	Name          string `json:"name"`
-	DecryptTraffic bool  `json:"decrypt_traffic"`
+	BundleID       string `json:"bundle_id"`
+	Name           string `json:"name"`
+	DecryptTraffic bool   `json:"decrypt_traffic"`
+	// Hosts the app talks to, so CONNECT requests without a recognizable
+	// user agent can still be attributed to it. Supports "*.example.com".
+	Hosts []string `json:"hosts,omitempty"`
 }
 
 type MonitoredAppsManager struct {
-	apps map[string]MonitoredApp
-	mu   sync.RWMutex
-	file string
+	apps      map[string]MonitoredApp
+	mu        sync.RWMutex
+	file      string
+	listeners notify.Listeners
 }
 
 func NewMonitoredAppsManager(configFile string) *MonitoredAppsManager {
@@ -31,13 +35,8 @@ func (m *MonitoredAppsManager) loadFromFile() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	data, err := ioutil.ReadFile(m.file)
-	if err != nil {
-		return err
-	}
-
 	var apps []MonitoredApp
-	if err := json.Unmarshal(data, &apps); err != nil {
+	if err := loadJSONFile(m.file, &apps); err != nil {
 		return err
 	}
 
@@ -48,33 +47,30 @@ func (m *MonitoredAppsManager) loadFromFile() error {
 }
 
 func (m *MonitoredAppsManager) saveToFile() error {
-	m.mu.RLock()
-	apps := make([]MonitoredApp, 0, len(m.apps))
-	for _, app := range m.apps {
-		apps = append(apps, app)
-	}
-	m.mu.RUnlock()
-
-	data, err := json.MarshalIndent(apps, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	return ioutil.WriteFile(m.file, data, 0644)
+	return saveJSONFile(m.file, m.ListApps())
 }
 
 func (m *MonitoredAppsManager) AddApp(app MonitoredApp) error {
 	m.mu.Lock()
 	m.apps[app.BundleID] = app
 	m.mu.Unlock()
-	return m.saveToFile()
+	err := m.saveToFile()
+	m.listeners.Notify()
+	return err
 }
 
 func (m *MonitoredAppsManager) RemoveApp(bundleID string) error {
 	m.mu.Lock()
 	delete(m.apps, bundleID)
 	m.mu.Unlock()
-	return m.saveToFile()
+	err := m.saveToFile()
+	m.listeners.Notify()
+	return err
+}
+
+// OnChange registers a callback invoked after every app change.
+func (m *MonitoredAppsManager) OnChange(fn func()) {
+	m.listeners.Add(fn)
 }
 
 func (m *MonitoredAppsManager) GetApp(bundleID string) (MonitoredApp, bool) {
@@ -84,6 +80,29 @@ func (m *MonitoredAppsManager) GetApp(bundleID string) (MonitoredApp, bool) {
 	return app, exists
 }
 
+// FindApp returns the monitored app matching the given identifier (bundle ID or
+// app name as reported in the user agent) or, failing that, owning the host.
+func (m *MonitoredAppsManager) FindApp(identifier, host string) (MonitoredApp, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if identifier != "" {
+		for _, app := range m.apps {
+			if strings.EqualFold(app.BundleID, identifier) || strings.EqualFold(app.Name, identifier) {
+				return app, true
+			}
+		}
+	}
+	for _, app := range m.apps {
+		for _, pattern := range app.Hosts {
+			if _, ok := matchHostPattern(pattern, host); ok {
+				return app, true
+			}
+		}
+	}
+	return MonitoredApp{}, false
+}
+
 func (m *MonitoredAppsManager) ListApps() []MonitoredApp {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"net"
+	"proxy_core/internal/notify"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PassThroughHost is a host whose CONNECT traffic is tunneled without
+// decryption, regardless of the app it comes from.
+type PassThroughHost struct {
+	Host    string    `json:"host"`
+	Reason  string    `json:"reason,omitempty"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+type PassThroughList struct {
+	hosts     map[string]PassThroughHost
+	mu        sync.RWMutex
+	file      string
+	listeners notify.Listeners
+}
+
+func NewPassThroughList(configFile string) *PassThroughList {
+	list := &PassThroughList{
+		hosts: make(map[string]PassThroughHost),
+		file:  configFile,
+	}
+	list.loadFromFile()
+	return list
+}
+
+func (l *PassThroughList) loadFromFile() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var hosts []PassThroughHost
+	if err := loadJSONFile(l.file, &hosts); err != nil {
+		return err
+	}
+
+	for _, host := range hosts {
+		l.hosts[normalizeHost(host.Host)] = host
+	}
+	return nil
+}
+
+func (l *PassThroughList) saveToFile() error {
+	return saveJSONFile(l.file, l.List())
+}
+
+func (l *PassThroughList) Add(host, reason string) error {
+	host = normalizeHost(host)
+	l.mu.Lock()
+	l.hosts[host] = PassThroughHost{Host: host, Reason: reason, AddedAt: time.Now()}
+	l.mu.Unlock()
+	err := l.saveToFile()
+	l.listeners.Notify()
+	return err
+}
+
+func (l *PassThroughList) Remove(host string) error {
+	l.mu.Lock()
+	delete(l.hosts, normalizeHost(host))
+	l.mu.Unlock()
+	err := l.saveToFile()
+	l.listeners.Notify()
+	return err
+}
+
+// Contains reports whether host (with or without port) is passed through.
+// Entries like "*.example.com" match every subdomain.
+func (l *PassThroughList) Contains(host string) bool {
+	host = normalizeHost(host)
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if _, ok := l.hosts[host]; ok {
+		return true
+	}
+	for pattern := range l.hosts {
+		if strings.HasPrefix(pattern, "*.") {
+			if _, ok := matchHostPattern(pattern, host); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (l *PassThroughList) List() []PassThroughHost {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	hosts := make([]PassThroughHost, 0, len(l.hosts))
+	for _, host := range l.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+	return hosts
+}
+
+// OnChange registers a callback invoked after every list change.
+func (l *PassThroughList) OnChange(fn func()) {
+	l.listeners.Add(fn)
+}
+
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
@@ -0,0 +1,292 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Breakpoint stages, see BreakpointRule.
+const (
+	StageRequest  = "request"
+	StageResponse = "response"
+)
+
+// Breakpoint actions, see BreakpointDecision.
+const (
+	BreakpointContinue = "continue"
+	BreakpointAbort    = "abort"
+)
+
+const defaultBreakpointTimeout = 60 * time.Second
+
+var ErrBreakpointNotFound = errors.New("no exchange paused with this ID")
+
+// PausedMessage is the editable side of a paused exchange: the request
+// (method, URL) or the response (status code). Bodies follow the log
+// convention, base64 when they are not UTF-8. Response bodies are shown
+// without their Content-Encoding.
+type PausedMessage struct {
+	Method     string      `json:"method,omitempty"`
+	URL        string      `json:"url,omitempty"`
+	StatusCode int         `json:"status_code,omitempty"`
+	Headers    http.Header `json:"headers"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 bool        `json:"body_base64,omitempty"`
+}
+
+// PausedExchange is a request or response held at a breakpoint. Response
+// is only set at StageResponse, Request is always there for context.
+type PausedExchange struct {
+	ID        string         `json:"id"`
+	LogID     uint64         `json:"log_id"`
+	RuleID    string         `json:"rule_id"`
+	Stage     string         `json:"stage"`
+	Request   PausedMessage  `json:"request"`
+	Response  *PausedMessage `json:"response,omitempty"`
+	PausedAt  time.Time      `json:"paused_at"`
+	ExpiresAt time.Time      `json:"expires_at"`
+}
+
+// BreakpointDecision releases a paused exchange. With the continue action,
+// Message replaces the paused request or response: it is meant to be the
+// message of the paused event, edited. Empty method, URL and status code and
+// nil headers keep the original values; the body is always taken.
+type BreakpointDecision struct {
+	Action  string         `json:"action"`
+	Message *PausedMessage `json:"message,omitempty"`
+}
+
+// breakpointReleased is the payload of EventBreakpointReleased.
+type breakpointReleased struct {
+	ID       string `json:"id"`
+	Action   string `json:"action"`
+	TimedOut bool   `json:"timed_out,omitempty"`
+}
+
+type pausedExchange struct {
+	PausedExchange
+	decision chan BreakpointDecision
+}
+
+// PausedExchanges returns the exchanges waiting at a breakpoint, oldest first.
+func (p *ProxyServer) PausedExchanges() []PausedExchange {
+	p.mu.RLock()
+	exchanges := make([]PausedExchange, 0, len(p.paused))
+	for _, exchange := range p.paused {
+		exchanges = append(exchanges, exchange.PausedExchange)
+	}
+	p.mu.RUnlock()
+	sort.Slice(exchanges, func(i, j int) bool { return exchanges[i].PausedAt.Before(exchanges[j].PausedAt) })
+	return exchanges
+}
+
+func (p *ProxyServer) GetPausedExchange(id string) (PausedExchange, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	exchange, ok := p.paused[id]
+	if !ok {
+		return PausedExchange{}, false
+	}
+	return exchange.PausedExchange, true
+}
+
+// ReleaseBreakpoint lets a paused exchange go on as the decision says.
+func (p *ProxyServer) ReleaseBreakpoint(id string, decision BreakpointDecision) error {
+	if decision.Action == "" {
+		decision.Action = BreakpointContinue
+	}
+	if decision.Action != BreakpointContinue && decision.Action != BreakpointAbort {
+		return fmt.Errorf("action must be %s or %s", BreakpointContinue, BreakpointAbort)
+	}
+	if message := decision.Message; message != nil && message.BodyBase64 {
+		if _, err := base64.StdEncoding.DecodeString(message.Body); err != nil {
+			return fmt.Errorf("invalid base64 body: %v", err)
+		}
+	}
+
+	p.mu.Lock()
+	exchange, ok := p.paused[id]
+	delete(p.paused, id)
+	p.mu.Unlock()
+	if !ok {
+		return ErrBreakpointNotFound
+	}
+	exchange.decision <- decision
+	return nil
+}
+
+// waitAtBreakpoint holds the exchange until the UI releases it. After the
+// timeout it continues unchanged; when the client goes away it is aborted.
+func (p *ProxyServer) waitAtBreakpoint(ctx context.Context, exchange PausedExchange) BreakpointDecision {
+	timeout := p.config.BreakpointTimeout
+	if timeout <= 0 {
+		timeout = defaultBreakpointTimeout
+	}
+	exchange.ID = newID()
+	exchange.PausedAt = time.Now()
+	exchange.ExpiresAt = exchange.PausedAt.Add(timeout)
+	paused := &pausedExchange{PausedExchange: exchange, decision: make(chan BreakpointDecision, 1)}
+
+	p.mu.Lock()
+	p.paused[exchange.ID] = paused
+	p.mu.Unlock()
+	p.notifyEvent(Event{Type: EventBreakpointPaused, Data: exchange})
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var decision BreakpointDecision
+	timedOut := false
+	select {
+	case decision = <-paused.decision:
+	case <-timer.C:
+		decision.Action = BreakpointContinue
+		timedOut = true
+	case <-ctx.Done():
+		decision.Action = BreakpointAbort
+	}
+
+	p.mu.Lock()
+	delete(p.paused, exchange.ID)
+	p.mu.Unlock()
+	p.notifyEvent(Event{Type: EventBreakpointReleased, Data: breakpointReleased{ID: exchange.ID, Action: decision.Action, TimedOut: timedOut}})
+	return decision
+}
+
+// breakOnRequest pauses a request before it is forwarded and applies the
+// edits it comes back with. It returns the body and host to use from then on.
+func (p *ProxyServer) breakOnRequest(req *http.Request, body []byte, host string, rule BreakpointRule, logEntry *RequestLog) ([]byte, string, bool) {
+	message := PausedMessage{Method: req.Method, URL: req.URL.String(), Headers: req.Header.Clone()}
+	message.Body, message.BodyBase64 = bodyText(body)
+
+	decision := p.waitAtBreakpoint(req.Context(), PausedExchange{
+		LogID:   logEntry.ID,
+		RuleID:  rule.ID,
+		Stage:   StageRequest,
+		Request: message,
+	})
+	if decision.Action == BreakpointAbort {
+		return body, host, true
+	}
+	if decision.Message == nil {
+		return body, host, false
+	}
+
+	edited := decision.Message
+	if edited.Method != "" {
+		req.Method = strings.ToUpper(edited.Method)
+	}
+	if edited.URL != "" {
+		if u, err := url.Parse(edited.URL); err == nil && u.Host != "" {
+			req.URL = u
+			req.Host = u.Host
+			host = u.Host
+		} else {
+			log.Printf("[BREAKPOINT] Ignoring invalid URL %q", edited.URL)
+		}
+	}
+	if edited.Headers != nil {
+		req.Header = edited.Headers.Clone()
+	}
+	body = pausedBody(edited)
+
+	logEntry.Method = req.Method
+	logEntry.URL = req.URL.String()
+	logEntry.RequestHeaders = make(map[string]string)
+	for k, v := range req.Header {
+		logEntry.RequestHeaders[k] = strings.Join(v, ", ")
+	}
+	capture := newBodyCapture(p.maxCaptureSize())
+	capture.Write(body)
+	logEntry.setRequestBody(capture.result(req.Header.Get("Content-Encoding"), p.maxCaptureSize()), req.Header.Get("Content-Type"))
+	return body, host, false
+}
+
+// breakOnResponse pauses a response before it is relayed and swaps in the
+// edited version, if any. Bodies too large to be captured are let through.
+func (p *ProxyServer) breakOnResponse(req *http.Request, resp *http.Response, rule BreakpointRule, logEntry *RequestLog) bool {
+	limit := p.maxCaptureSize()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(limit)+1))
+	if err != nil || len(data) > limit {
+		log.Printf("[BREAKPOINT] Not pausing %s: response body too large", logEntry.URL)
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), resp.Body), resp.Body}
+		return false
+	}
+
+	// The UI edits the decoded body
+	message := PausedMessage{StatusCode: resp.StatusCode, Headers: resp.Header.Clone()}
+	display := data
+	if contentEncoding := resp.Header.Get("Content-Encoding"); contentEncoding != "" {
+		if decoded, err := decodeContent(data, contentEncoding, limit); err == nil && len(decoded) <= limit {
+			display = decoded
+			message.Headers.Del("Content-Encoding")
+			message.Headers.Del("Content-Length")
+		}
+	}
+	message.Body, message.BodyBase64 = bodyText(display)
+
+	decision := p.waitAtBreakpoint(req.Context(), PausedExchange{
+		LogID:    logEntry.ID,
+		RuleID:   rule.ID,
+		Stage:    StageResponse,
+		Request:  PausedMessage{Method: req.Method, URL: req.URL.String(), Headers: req.Header.Clone()},
+		Response: &message,
+	})
+	if decision.Action == BreakpointAbort {
+		return true
+	}
+	if decision.Message == nil {
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		return false
+	}
+
+	edited := decision.Message
+	if edited.StatusCode != 0 {
+		resp.StatusCode = edited.StatusCode
+	}
+	header := message.Headers
+	if edited.Headers != nil {
+		header = edited.Headers.Clone()
+	}
+	body := pausedBody(edited)
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.Header = header
+	resp.ContentLength = int64(len(body))
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return false
+}
+
+// abortAtBreakpoint answers the client of an aborted exchange.
+func (p *ProxyServer) abortAtBreakpoint(w http.ResponseWriter, logEntry RequestLog) RequestLog {
+	logEntry.StatusCode = http.StatusBadGateway
+	logEntry.Error = "aborted at breakpoint"
+	logEntry.Completed = time.Now()
+	logEntry.ResponseTime = logEntry.Completed.Sub(logEntry.Timestamp)
+	p.addLog(logEntry)
+	http.Error(w, "Aborted at breakpoint", http.StatusBadGateway)
+	return logEntry
+}
+
+// pausedBody decodes the body of a message, already validated by
+// ReleaseBreakpoint.
+func pausedBody(message *PausedMessage) []byte {
+	if message.BodyBase64 {
+		data, _ := base64.StdEncoding.DecodeString(message.Body)
+		return data
+	}
+	return []byte(message.Body)
+}
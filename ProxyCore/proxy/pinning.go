@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// A connection closed without requests this soon after the handshake is
+// treated as a rejected certificate rather than an unused connection.
+const pinningCloseWindow = time.Second
+
+// Log entry kinds. Regular requests leave Kind empty.
+const (
+	KindTLSFailure = "tls_failure"
+)
+
+// TLS failure reasons, as reported in TLSFailure.Reason.
+const (
+	TLSFailureUnknownCA          = "unknown_ca"
+	TLSFailureBadCertificate     = "bad_certificate"
+	TLSFailureCertificateUnknown = "certificate_unknown"
+	TLSFailureClosed             = "closed_after_server_hello"
+	TLSFailureOther              = "handshake_error"
+)
+
+// TLSFailure describes a client rejecting the intercepted handshake, which
+// usually means the app pins its certificate.
+type TLSFailure struct {
+	Reason string `json:"reason"`
+	Error  string `json:"error"`
+	// Failures seen for this host since the last successful handshake
+	Count int `json:"count"`
+	// Set when this failure moved the host to the pass-through list
+	PassThrough bool `json:"pass_through,omitempty"`
+}
+
+// classifyTLSFailure maps the error returned by the handshake (or by the first
+// read right after it) to one of the TLSFailure reasons.
+func classifyTLSFailure(err error) string {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "unknown certificate authority"):
+		return TLSFailureUnknownCA
+	case strings.Contains(msg, "bad certificate"):
+		return TLSFailureBadCertificate
+	case strings.Contains(msg, "certificate unknown"):
+		return TLSFailureCertificateUnknown
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, syscall.ECONNRESET):
+		return TLSFailureClosed
+	}
+	return TLSFailureOther
+}
+
+func isTLSAlert(err error) bool {
+	return strings.Contains(err.Error(), "remote error: tls:")
+}
+
+// reportTLSFailure logs a rejected handshake for the CONNECT described by
+// logEntry and, once the configured threshold is reached, adds the host to the
+// pass-through list so the next connection is tunneled.
+func (p *ProxyServer) reportTLSFailure(logEntry RequestLog, host string, err error) {
+	host = normalizeHost(host)
+
+	p.mu.Lock()
+	p.tlsFailures[host]++
+	count := p.tlsFailures[host]
+	p.mu.Unlock()
+
+	failure := &TLSFailure{
+		Reason: classifyTLSFailure(err),
+		Error:  err.Error(),
+		Count:  count,
+	}
+	log.Printf("[PINNING] %s rejected the certificate for %s (%s, %d failures)", logEntry.ClientIP, host, failure.Reason, count)
+
+	threshold := p.config.PinningPassThroughThreshold
+	if threshold > 0 && count >= threshold && failure.Reason != TLSFailureOther && !p.passThrough.Contains(host) {
+		if err := p.passThrough.Add(host, "pinning"); err != nil {
+			log.Printf("[PINNING] Error saving pass-through list: %v", err)
+		}
+		failure.PassThrough = true
+		log.Printf("[PINNING] %s added to the pass-through list", host)
+	}
+
+	logEntry.Kind = KindTLSFailure
+	logEntry.TLSFailure = failure
+	logEntry.Completed = time.Now()
+	logEntry.ResponseTime = logEntry.Completed.Sub(logEntry.Timestamp)
+	p.addLog(logEntry)
+}
+
+// resetTLSFailures clears the failure count once a client accepted our leaf.
+func (p *ProxyServer) resetTLSFailures(host string) {
+	host = normalizeHost(host)
+	p.mu.Lock()
+	delete(p.tlsFailures, host)
+	p.mu.Unlock()
+}
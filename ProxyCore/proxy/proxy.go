@@ -2,35 +2,75 @@ package proxy
 
 import (
 	"bufio"
-	"bytes"
-	"compress/gzip"
 	"crypto/tls"
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"net/http"
 	"proxy_core/cert"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/mssola/user_agent"
+	"golang.org/x/net/http2"
 )
 
 type RequestLog struct {
+	// ID is assigned when the request starts and increases monotonically
+	ID uint64 `json:"id"`
+
+	// Kind tells special entries apart, e.g. KindTLSFailure. Empty for requests
+	Kind string `json:"kind,omitempty"`
+	// ReplayOf is the ID of the entry this one replays, with its session when
+	// it was not the current one
+	ReplayOf        uint64 `json:"replay_of,omitempty"`
+	ReplayOfSession string `json:"replay_of_session,omitempty"`
+
 	// Request info
-	Method         string            `json:"method"`
-	URL            string            `json:"url"`
-	Protocol       string            `json:"protocol"`
-	ClientIP       string            `json:"client_ip"`
-	RequestHeaders map[string]string `json:"request_headers"`
-	RequestBody    string            `json:"request_body,omitempty"`
+	Method   string `json:"method"`
+	URL      string `json:"url"`
+	Protocol string `json:"protocol"`
+	// HTTP version spoken with the client and with the origin, e.g. "HTTP/2.0"
+	ClientProtocol   string            `json:"client_protocol,omitempty"`
+	UpstreamProtocol string            `json:"upstream_protocol,omitempty"`
+	ClientIP         string            `json:"client_ip"`
+	RequestHeaders   map[string]string `json:"request_headers"`
+	RequestBody      string            `json:"request_body,omitempty"`
+	// Bodies are text when valid UTF-8, base64 otherwise (see body.go)
+	RequestBodyBase64 bool   `json:"request_body_base64,omitempty"`
+	RequestMIMEType   string `json:"request_mime_type,omitempty"`
+	RawRequestBody    []byte `json:"-"`
+	// Body sizes as sent on the wire and once the Content-Encoding is removed.
+	// The decoded size is zero when it is not known.
+	RequestBodySize        int64 `json:"request_body_size,omitempty"`
+	RequestBodyDecodedSize int64 `json:"request_body_decoded_size,omitempty"`
+	RequestBodyTruncated   bool  `json:"request_body_truncated,omitempty"`
 
 	// Response info
-	StatusCode      int               `json:"status_code"`
-	ResponseHeaders map[string]string `json:"response_headers"`
-	ResponseBody    string            `json:"response_body,omitempty"`
-	ResponseTime    time.Duration     `json:"response_time_ms"`
+	StatusCode         int               `json:"status_code"`
+	ResponseHeaders    map[string]string `json:"response_headers"`
+	ResponseBody       string            `json:"response_body,omitempty"`
+	ResponseBodyBase64 bool              `json:"response_body_base64,omitempty"`
+	ResponseMIMEType   string            `json:"response_mime_type,omitempty"`
+	RawResponseBody    []byte            `json:"-"`
+	ResponseTime       time.Duration     `json:"response_time_ms"`
+	// Error tells why no (complete) response was received
+	Error string `json:"error,omitempty"`
+	// Mocked is set when the response came from a mock rule
+	Mocked bool `json:"mocked,omitempty"`
+	// Same as the request body sizes
+	ResponseBodySize        int64 `json:"response_body_size,omitempty"`
+	ResponseBodyDecodedSize int64 `json:"response_body_decoded_size,omitempty"`
+	ResponseBodyTruncated   bool  `json:"response_body_truncated,omitempty"`
+	// Streamed is set when the body was relayed to the client as it arrived
+	// (event streams, chunked or large bodies)
+	Streamed  bool       `json:"streamed,omitempty"`
+	SSEEvents []SSEEvent `json:"sse_events,omitempty"`
 
 	// Timing
 	Timestamp time.Time `json:"timestamp"`
@@ -41,58 +81,115 @@ type RequestLog struct {
 	DeviceInfo    string `json:"device_info,omitempty"`
 	IsSimulator   bool   `json:"is_simulator"`
 	AppIdentifier string `json:"app_identifier,omitempty"`
-}
 
-type ProxyServer struct {
-	certManager *cert.CertManager
-	logs        []RequestLog
-	mu          sync.RWMutex
-	appsManager *MonitoredAppsManager
-	clients     map[chan RequestLog]struct{}
-	mockManager *MockManager
-}
+	// TLS handshake details for intercepted HTTPS traffic
+	ClientTLS            *TLSInfo                  `json:"client_tls,omitempty"`
+	UpstreamTLS          *TLSInfo                  `json:"upstream_tls,omitempty"`
+	UpstreamCertificates []cert.CertificateSummary `json:"upstream_certificates,omitempty"`
+	TLSFailure           *TLSFailure               `json:"tls_failure,omitempty"`
 
-func (p *ProxyServer) Subscribe() chan RequestLog {
-	ch := make(chan RequestLog)
-	p.mu.Lock()
-	p.clients[ch] = struct{}{}
-	p.mu.Unlock()
-	return ch
+	// WebSocketID links a KindWebSocket entry to its frames
+	WebSocketID string `json:"websocket_id,omitempty"`
+
+	// Tunnel info, only set for CONNECT requests that were not decrypted.
+	// Byte counts are also filled in for WebSocket connections.
+	Tunneled bool  `json:"tunneled,omitempty"`
+	BytesIn  int64 `json:"bytes_in,omitempty"`
+	BytesOut int64 `json:"bytes_out,omitempty"`
 }
 
-func (p *ProxyServer) Unsubscribe(ch chan RequestLog) {
-	p.mu.Lock()
-	delete(p.clients, ch)
-	p.mu.Unlock()
-	close(ch)
+type Config struct {
+	// MimicUpstream completes a TLS handshake with the origin before answering
+	// the client and mints a leaf copying the real certificate's subject, SANs
+	// and validity
+	MimicUpstream bool
+	// PinningPassThroughThreshold moves a host to the pass-through list after
+	// this many rejected handshakes. Zero disables it.
+	PinningPassThroughThreshold int
+	// DisableHTTP2 keeps intercepted connections on HTTP/1.1 on both sides
+	DisableHTTP2 bool
+	// MaxCaptureSize bounds how many bytes of each response body are kept for
+	// the log. Zero uses the 4 MiB default.
+	MaxCaptureSize int
+	// Store persists the logs in sessions. Nil keeps them only in memory.
+	Store LogStore
+	// BreakpointTimeout is how long an exchange waits at a breakpoint before
+	// it continues unchanged. Zero uses one minute.
+	BreakpointTimeout time.Duration
 }
 
-func (p *ProxyServer) notifySubscribers(log RequestLog) {
-	p.mu.RLock()
-	for ch := range p.clients {
-		select {
-		case ch <- log:
-		default:
-			// Skip if channel is full
-		}
-	}
-	p.mu.RUnlock()
+type ProxyServer struct {
+	config       Config
+	certManager  *cert.CertManager
+	logs         []RequestLog
+	mu           sync.RWMutex
+	appsManager  *MonitoredAppsManager
+	eventClients map[chan Event]struct{}
+	frames       []WebSocketFrame
+	frameClients map[chan WebSocketFrame]struct{}
+	mockManager  *MockManager
+	collections  *CollectionManager
+	breakpoints  *BreakpointManager
+	paused       map[string]*pausedExchange
+	passThrough  *PassThroughList
+	tlsFailures  map[string]int
+	transport    *http.Transport
+	nextLogID    uint64
+	inflight     map[uint64]RequestLog
+	sessionID    string
+
+	// Finished logs wait here for writeLogs to persist them
+	persistMu     sync.RWMutex
+	persistQueue  chan pendingLog
+	persistDone   chan struct{}
+	persistClosed bool
+
+	// Transports for composed requests that force an HTTP version
+	composeOnce    sync.Once
+	http1Transport *http.Transport
+	http2Transport *http2.Transport
 }
 
 func (p *ProxyServer) Start(addr string) error {
 	return http.ListenAndServe(addr, p)
 }
 
+// startLog assigns the entry its ID and announces it over /ws while the
+// request is still in flight.
+func (p *ProxyServer) startLog(entry *RequestLog) {
+	p.mu.Lock()
+	p.nextLogID++
+	entry.ID = p.nextLogID
+	// The handler keeps filling in its copy, don't share the maps
+	snapshot := *entry
+	snapshot.RequestHeaders = maps.Clone(entry.RequestHeaders)
+	snapshot.ResponseHeaders = maps.Clone(entry.ResponseHeaders)
+	p.inflight[entry.ID] = snapshot
+	p.mu.Unlock()
+
+	p.notifyEvent(Event{Type: EventLogStarted, Data: snapshot})
+}
+
+// addLog stores a finished entry. Entries that skipped startLog, e.g. TLS
+// failures, get their ID here.
 func (p *ProxyServer) addLog(log RequestLog) {
 	p.mu.Lock()
-	p.logs = append(p.logs, log)
+	if log.ID == 0 {
+		p.nextLogID++
+		log.ID = p.nextLogID
+	}
+	delete(p.inflight, log.ID)
+	// Requests complete out of order: keep the logs sorted by ID, which is
+	// what queries and cursors rely on
+	i := sort.Search(len(p.logs), func(i int) bool { return p.logs[i].ID > log.ID })
+	p.logs = slices.Insert(p.logs, i, log)
 	if len(p.logs) > 1000 { // Keep last 1000 logs
 		p.logs = p.logs[1:]
 	}
 	p.mu.Unlock()
 
-	// Notify subscribers
-	p.notifySubscribers(log)
+	p.notifyEvent(Event{Type: EventLogCompleted, Data: log})
+	p.persistLog(log)
 }
 
 func (p *ProxyServer) GetLogs() []RequestLog {
@@ -101,13 +198,104 @@ func (p *ProxyServer) GetLogs() []RequestLog {
 	return append([]RequestLog{}, p.logs...)
 }
 
-func NewProxyServer(certManager *cert.CertManager) *ProxyServer {
-	return &ProxyServer{
-		certManager: certManager,
-		appsManager: NewMonitoredAppsManager("monitored_apps.json"),
-		clients:     make(map[chan RequestLog]struct{}),
-		mockManager: NewMockManager("mocks.json"),
+// GetLog returns the log entry with the given ID, if it is still kept. Entries
+// still in flight are returned as they were when the request started.
+func (p *ProxyServer) GetLog(id uint64) (RequestLog, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if entry, ok := p.inflight[id]; ok {
+		return entry, true
 	}
+	for i := len(p.logs) - 1; i >= 0; i-- {
+		if p.logs[i].ID == id {
+			return p.logs[i], true
+		}
+	}
+	return RequestLog{}, false
+}
+
+func NewProxyServer(certManager *cert.CertManager, config Config) *ProxyServer {
+	p := &ProxyServer{
+		config:       config,
+		certManager:  certManager,
+		appsManager:  NewMonitoredAppsManager("monitored_apps.json"),
+		inflight:     make(map[uint64]RequestLog),
+		eventClients: make(map[chan Event]struct{}),
+		frameClients: make(map[chan WebSocketFrame]struct{}),
+		mockManager:  NewMockManager("mocks.json"),
+		collections:  NewCollectionManager("collections.json"),
+		breakpoints:  NewBreakpointManager("breakpoints.json"),
+		paused:       make(map[string]*pausedExchange),
+		passThrough:  NewPassThroughList("passthrough_hosts.json"),
+		tlsFailures:  make(map[string]int),
+		transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2:   !config.DisableHTTP2,
+			DisableCompression:  true,
+			MaxIdleConnsPerHost: 16,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+
+	p.mockManager.OnChange(func() {
+		p.notifyEvent(Event{Type: EventMocksChanged, Data: p.mockManager.ListMocks()})
+	})
+	p.collections.OnChange(func() {
+		p.notifyEvent(Event{Type: EventCollectionsChanged, Data: p.collections.ListCollections()})
+	})
+	p.breakpoints.OnChange(func() {
+		p.notifyEvent(Event{Type: EventBreakpointsChanged, Data: p.breakpoints.ListRules()})
+	})
+	p.appsManager.OnChange(func() {
+		p.notifyEvent(Event{Type: EventAppsChanged, Data: p.appsManager.ListApps()})
+	})
+	p.passThrough.OnChange(func() {
+		p.notifyEvent(Event{Type: EventPassThroughChanged, Data: p.passThrough.List()})
+	})
+	certManager.OnChange(func() {
+		p.notifyEvent(Event{Type: EventCAChanged, Data: certManager.CAInfo()})
+	})
+
+	if config.Store != nil {
+		p.persistQueue = make(chan pendingLog, persistQueueSize)
+		p.persistDone = make(chan struct{})
+		go p.writeLogs()
+		if _, err := p.StartSession(""); err != nil {
+			log.Printf("Failed to create a session, logs will not be saved: %v", err)
+		}
+	}
+	return p
+}
+
+// CertManager returns the certificate manager used to intercept HTTPS.
+func (p *ProxyServer) CertManager() *cert.CertManager {
+	return p.certManager
+}
+
+// PassThroughList returns the hosts that are never decrypted.
+func (p *ProxyServer) PassThroughList() *PassThroughList {
+	return p.passThrough
+}
+
+// AppsManager returns the monitored apps manager used by the running proxy.
+func (p *ProxyServer) AppsManager() *MonitoredAppsManager {
+	return p.appsManager
+}
+
+// MockManager returns the mock manager used by the running proxy.
+func (p *ProxyServer) MockManager() *MockManager {
+	return p.mockManager
+}
+
+// BreakpointManager returns the breakpoint rules.
+func (p *ProxyServer) BreakpointManager() *BreakpointManager {
+	return p.breakpoints
+}
+
+// CollectionManager returns the saved composer requests.
+func (p *ProxyServer) CollectionManager() *CollectionManager {
+	return p.collections
 }
 
 func (p *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -117,6 +305,7 @@ func (p *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		Method:          r.Method,
 		URL:             r.URL.String(),
 		Protocol:        r.Proto,
+		ClientProtocol:  r.Proto,
 		RequestHeaders:  make(map[string]string),
 		ResponseHeaders: make(map[string]string),
 	}
@@ -137,58 +326,12 @@ func (p *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Always try to capture request body if present
-	if r.Body != nil {
-		body, err := io.ReadAll(r.Body)
-		if err == nil && len(body) > 0 {
-			logEntry.RequestBody = string(body)
-			// Restore body for forwarding
-			r.Body = io.NopCloser(bytes.NewBuffer(body))
-		}
-	}
-
-	// For HTTP requests, capture headers
-	for k, v := range r.Header {
-		logEntry.RequestHeaders[k] = strings.Join(v, ", ")
-	}
-
-	// Forward the request
-	resp, err := http.DefaultTransport.RoundTrip(r)
-	if err != nil {
-		logEntry.StatusCode = http.StatusBadGateway
-		p.addLog(logEntry)
-		http.Error(w, err.Error(), http.StatusBadGateway)
-		return
-	}
-	defer resp.Body.Close()
-
-	// Copy response headers
-	for k, v := range resp.Header {
-		logEntry.ResponseHeaders[k] = strings.Join(v, ", ")
-		w.Header().Set(k, strings.Join(v, ", "))
-	}
-
-	// Set status code
-	w.WriteHeader(resp.StatusCode)
-	logEntry.StatusCode = resp.StatusCode
-
-	// Always try to capture response body if present
-	body, err := io.ReadAll(resp.Body)
-	if err == nil && len(body) > 0 {
-		logEntry.ResponseBody = string(body)
-		// Write body to response
-		w.Write(body)
-	} else {
-		// If we couldn't read the body, stream it directly
-		io.Copy(w, resp.Body)
-	}
-
-	// Complete the log
-	logEntry.Completed = time.Now()
-	logEntry.ResponseTime = logEntry.Completed.Sub(logEntry.Timestamp)
-	p.addLog(logEntry)
+	p.forward(w, r, r.Host, logEntry)
 }
 
+// recordTypeHandshake is the first byte of a TLS ClientHello
+const recordTypeHandshake = 0x16
+
 func (p *ProxyServer) handleHTTPS(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[HTTPS] Nuova richiesta da %s a %s", r.RemoteAddr, r.Host)
 
@@ -212,18 +355,20 @@ func (p *ProxyServer) handleHTTPS(w http.ResponseWriter, r *http.Request) {
 	logEntry.DeviceInfo = fmt.Sprintf("%s %s / %s %s", ua.Platform(), ua.OS(), browser, version)
 	if ua.OS() == "iOS" && strings.Contains(r.UserAgent(), "Simulator") {
 		logEntry.IsSimulator = true
-		bundleID := r.Header.Get("X-Bundle-ID")
-		if bundleID == "" {
-			bundleID = r.Header.Get("CFBundleIdentifier")
-		}
-		if bundleID == "" {
-			if parts := strings.Split(r.UserAgent(), "CFNetwork"); len(parts) > 1 {
-				if app := strings.TrimSpace(parts[0]); app != "" {
-					bundleID = app
-				}
+	}
+	bundleID := r.Header.Get("X-Bundle-ID")
+	if bundleID == "" {
+		bundleID = r.Header.Get("CFBundleIdentifier")
+	}
+	if bundleID == "" {
+		// CFNetwork user agents look like "AppName/1.0 CFNetwork/1490 Darwin/23.0"
+		if parts := strings.Split(r.UserAgent(), "CFNetwork"); len(parts) > 1 {
+			if app := strings.TrimSpace(parts[0]); app != "" {
+				bundleID = strings.SplitN(app, "/", 2)[0]
 			}
 		}
 	}
+	logEntry.AppIdentifier = bundleID
 
 	hijacker, ok := w.(http.Hijacker)
 	if !ok {
@@ -233,155 +378,205 @@ func (p *ProxyServer) handleHTTPS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	clientConn, _, err := hijacker.Hijack()
+	rawConn, clientRW, err := hijacker.Hijack()
 	if err != nil {
 		logEntry.StatusCode = http.StatusInternalServerError
 		p.addLog(logEntry)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer clientConn.Close()
+	defer rawConn.Close()
+	// Clients that don't wait for the 200 may have sent their first bytes
+	// along with the CONNECT; the server has already buffered them
+	clientReader := clientRW.Reader
+	clientConn := &bufferedConn{Conn: rawConn, reader: clientReader}
+
+	if !p.shouldDecrypt(r.Host, bundleID) {
+		p.handleTunnel(clientConn, r.Host, logEntry)
+		return
+	}
 
-	cert, err := p.certManager.GenerateCertificate(r.Host)
+	// In mimic mode the leaf for the CONNECT host is only a fallback, made
+	// when needed
+	var leaf *tls.Certificate
+	if !p.config.MimicUpstream {
+		leaf, err = p.certManager.GenerateCertificate(r.Host)
+		if err != nil {
+			logEntry.StatusCode = http.StatusInternalServerError
+			p.addLog(logEntry)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	_, err = clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
 	if err != nil {
 		logEntry.StatusCode = http.StatusInternalServerError
 		p.addLog(logEntry)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	_, err = clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+	// Some clients (e.g. for ws:// through a proxy) tunnel plain HTTP with
+	// CONNECT: a TLS connection always starts with a handshake record
+	first, err := clientReader.Peek(1)
 	if err != nil {
-		logEntry.StatusCode = http.StatusInternalServerError
-		p.addLog(logEntry)
+		return
+	}
+	if first[0] != recordTypeHandshake {
+		logEntry.Protocol = "HTTP"
+		serveConn(clientConn, p.interceptHandler(r, "http", logEntry))
 		return
 	}
 
+	var upstreamChain []cert.CertificateSummary
+	var clientTLS *TLSInfo
 	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{*cert},
+		NextProtos: p.nextProtos(),
 		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			clientTLS = clientHelloInfo(hello)
+
+			if p.config.MimicUpstream {
+				mimic, chain, err := p.mimicCertificate(r.Host, hello)
+				if err == nil {
+					upstreamChain = chain
+					return mimic, nil
+				}
+				log.Printf("[MIMIC] Falling back to a generated certificate for %s: %v", r.Host, err)
+			}
+
 			// Rende più robusto il supporto SNI per i browser
-			return p.certManager.GenerateCertificate(hello.ServerName)
+			if hello.ServerName == "" {
+				// Nessun SNI: usa il certificato generato per l'host del CONNECT
+				if leaf == nil {
+					return p.certManager.GenerateCertificate(r.Host)
+				}
+				return leaf, nil
+			}
+			return p.certManager.GetCertificate(hello)
 		},
 	}
 	tlsConn := tls.Server(clientConn, tlsConfig)
 	defer tlsConn.Close()
 
-	reader := bufio.NewReader(tlsConn)
+	if err := tlsConn.Handshake(); err != nil {
+		log.Printf("[HTTPS] Handshake with %s failed: %v", r.RemoteAddr, err)
+		// Without a ClientHello there was no certificate to reject
+		if clientTLS != nil {
+			logEntry.ClientTLS = clientTLS
+			p.reportTLSFailure(logEntry, r.Host, err)
+		}
+		return
+	}
+	if clientTLS != nil {
+		clientTLS.applyConnectionState(tlsConn.ConnectionState())
+	}
+	logEntry.ClientTLS = clientTLS
+	logEntry.UpstreamCertificates = upstreamChain
+	handshakeDone := time.Now()
 
-	for {
-		req, err := http.ReadRequest(reader)
-		if err != nil {
-			if err != io.EOF {
-				log.Printf("Error reading request: %v", err)
-			}
-			break
+	// Peek so that a client hanging up before its first request can be told
+	// apart from one that actually talks to us
+	reader := bufio.NewReader(tlsConn)
+	if _, err := reader.Peek(1); err != nil {
+		// A client that rejects the leaf may only say so right after the
+		// handshake, before sending any request: with an alert, or by just
+		// hanging up when the app validates the certificate itself
+		closedEarly := err == io.EOF && time.Since(handshakeDone) < pinningCloseWindow
+		if isTLSAlert(err) || closedEarly {
+			p.reportTLSFailure(logEntry, r.Host, err)
+		} else if err != io.EOF {
+			log.Printf("Error reading request: %v", err)
 		}
+		return
+	}
+	p.resetTLSFailures(r.Host)
+
+	handler := p.interceptHandler(r, "https", logEntry)
+	conn := &bufferedConn{Conn: tlsConn, reader: reader}
+	if tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
+		(&http2.Server{}).ServeConn(conn, &http2.ServeConnOpts{Handler: handler})
+		return
+	}
+	serveConn(conn, handler)
+}
 
+// interceptHandler serves the requests a client sends inside a CONNECT tunnel.
+// Each request gets its own log entry, carrying over the device and TLS details
+// of the tunnel.
+func (p *ProxyServer) interceptHandler(connect *http.Request, scheme string, tunnelEntry RequestLog) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		reqLog := RequestLog{
 			Timestamp:       time.Now(),
 			Method:          req.Method,
-			URL:             "https://" + r.Host + req.URL.String(),
-			Protocol:        "HTTPS",
-			ClientIP:        r.RemoteAddr,
+			URL:             scheme + "://" + connect.Host + req.URL.String(),
+			Protocol:        tunnelEntry.Protocol,
+			ClientProtocol:  req.Proto,
+			ClientIP:        connect.RemoteAddr,
 			RequestHeaders:  make(map[string]string),
 			ResponseHeaders: make(map[string]string),
 			UserAgent:       req.UserAgent(),
-		}
+			DeviceInfo:      tunnelEntry.DeviceInfo,
+			IsSimulator:     tunnelEntry.IsSimulator,
+			AppIdentifier:   tunnelEntry.AppIdentifier,
 
-		for k, v := range req.Header {
-			reqLog.RequestHeaders[k] = strings.Join(v, ", ")
+			ClientTLS:            tunnelEntry.ClientTLS,
+			UpstreamCertificates: tunnelEntry.UpstreamCertificates,
 		}
 
-		if req.Body != nil {
-			var bodyReader io.Reader = req.Body
-			if req.Header.Get("Content-Encoding") == "gzip" {
-				gzReader, err := gzip.NewReader(req.Body)
-				if err == nil {
-					bodyReader = gzReader
-					defer gzReader.Close()
-				}
-			}
-			body, err := io.ReadAll(bodyReader)
-			if err == nil && len(body) > 0 {
-				reqLog.RequestBody = string(body)
-				req.Body = io.NopCloser(bytes.NewBuffer(body))
-			}
-		}
-
-		// 🔥 MATCH MOCK PRIMA DI INOLTRARE LA RICHIESTA
-		if mockResp, _ := p.mockManager.Match(req.Method, r.Host, req.URL.Path); mockResp != nil {
-			if mockResp.LatencyMs > 0 {
-				time.Sleep(time.Duration(mockResp.LatencyMs) * time.Millisecond)
-			}
-			reqLog.StatusCode = mockResp.StatusCode
-			reqLog.ResponseHeaders = map[string]string{"X-Mock-Response": "true"}
-			reqLog.ResponseBody = mockResp.Response
-			reqLog.Completed = time.Now()
-			reqLog.ResponseTime = reqLog.Completed.Sub(reqLog.Timestamp)
-
-			// Usa una risposta HTTP formattata correttamente
-			resp := fmt.Sprintf("HTTP/1.1 %d %s\r\nContent-Length: %d\r\nContent-Type: %s\r\nX-Mock-Response: true\r\n\r\n%s",
-				mockResp.StatusCode,
-				http.StatusText(mockResp.StatusCode),
-				len(mockResp.Response),
-				mockResp.ContentType, // o "application/json"
-				mockResp.Response,
-			)
-			log.Println(resp)
-			tlsConn.Write([]byte(resp))
-			p.addLog(reqLog)
-			continue
-		}
-
-		transport := &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
-		client := &http.Client{Transport: transport}
-
-		outReq, err := http.NewRequest(req.Method, reqLog.URL, req.Body)
-		if err != nil {
-			reqLog.StatusCode = http.StatusBadGateway
-			p.addLog(reqLog)
-			continue
-		}
-		outReq.Header = req.Header
+		req.URL.Scheme = scheme
+		req.URL.Host = connect.Host
+		p.forward(w, req, connect.Host, reqLog)
+	})
+}
 
-		resp, err := client.Do(outReq)
-		if err != nil {
-			reqLog.StatusCode = http.StatusBadGateway
-			p.addLog(reqLog)
-			continue
-		}
+// matchMock looks up a mock rule for the request. When one applies it waits for
+// the configured latency, fills in the log entry and returns the response to
+// send back to the client; otherwise it returns nil and the request should be
+// forwarded upstream.
+func (p *ProxyServer) matchMock(req *http.Request, host string, logEntry *RequestLog) *http.Response {
+	mockResp, ok := p.mockManager.Match(req.Method, host, req.URL.Path)
+	if !ok {
+		return nil
+	}
 
-		reqLog.StatusCode = resp.StatusCode
-		for k, v := range resp.Header {
-			reqLog.ResponseHeaders[k] = strings.Join(v, ", ")
-		}
+	if mockResp.LatencyMs > 0 {
+		time.Sleep(time.Duration(mockResp.LatencyMs) * time.Millisecond)
+	}
 
-		if resp.Body != nil {
-			var bodyReader io.Reader = resp.Body
-			if resp.Header.Get("Content-Encoding") == "gzip" {
-				gzReader, err := gzip.NewReader(resp.Body)
-				if err == nil {
-					bodyReader = gzReader
-					defer gzReader.Close()
-				}
-			}
-			body, err := io.ReadAll(bodyReader)
-			if err == nil && len(body) > 0 {
-				reqLog.ResponseBody = string(body)
-				resp.Body = io.NopCloser(bytes.NewBuffer(body))
-			}
-		}
+	contentType := mockResp.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
 
-		if err := resp.Write(tlsConn); err != nil {
-			log.Printf("Error writing response: %v", err)
-			break
-		}
+	resp := &http.Response{
+		StatusCode:    mockResp.StatusCode,
+		Status:        fmt.Sprintf("%d %s", mockResp.StatusCode, http.StatusText(mockResp.StatusCode)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          io.NopCloser(strings.NewReader(mockResp.Response)),
+		ContentLength: int64(len(mockResp.Response)),
+		Request:       req,
+	}
+	resp.Header.Set("Content-Type", contentType)
+	resp.Header.Set("Content-Length", strconv.Itoa(len(mockResp.Response)))
+	resp.Header.Set("X-Mock-Response", "true")
 
-		reqLog.Completed = time.Now()
-		reqLog.ResponseTime = reqLog.Completed.Sub(reqLog.Timestamp)
-		p.addLog(reqLog)
+	logEntry.StatusCode = mockResp.StatusCode
+	logEntry.Mocked = true
+	logEntry.ResponseHeaders = make(map[string]string)
+	for k, v := range resp.Header {
+		logEntry.ResponseHeaders[k] = strings.Join(v, ", ")
 	}
+	logEntry.setResponseBody(capturedBody{
+		data:        []byte(mockResp.Response),
+		size:        int64(len(mockResp.Response)),
+		decodedSize: int64(len(mockResp.Response)),
+	}, contentType)
+	logEntry.Completed = time.Now()
+	logEntry.ResponseTime = logEntry.Completed.Sub(logEntry.Timestamp)
+
+	log.Printf("[MOCK] %s %s%s -> %d (mock %s)", req.Method, host, req.URL.Path, mockResp.StatusCode, mockResp.ID)
+	return resp
 }
@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxReplayRepeat      = 1000
+	maxReplayConcurrency = 50
+)
+
+// ReplayRequest describes a replay of a logged request. Empty fields keep
+// the captured values.
+type ReplayRequest struct {
+	Method string `json:"method,omitempty"`
+	URL    string `json:"url,omitempty"`
+	// Headers are merged into the captured ones, a null value removes one
+	Headers map[string]*string `json:"headers,omitempty"`
+	Body    *string            `json:"body,omitempty"`
+	// BodyBase64 tells that Body is base64, for binary payloads
+	BodyBase64 bool `json:"body_base64,omitempty"`
+
+	// Repeat sends the request this many times (default 1), Concurrency at a
+	// time (default 1)
+	Repeat      int `json:"repeat,omitempty"`
+	Concurrency int `json:"concurrency,omitempty"`
+}
+
+// Replay sends a logged request again through forward, the same path proxied
+// requests take, mocks included. Every attempt is logged as a new entry
+// pointing back to the original; they are returned in the order they were
+// started. sessionID is the session the original belongs to, empty for the
+// current one. Once ctx is canceled no more attempts are started.
+func (p *ProxyServer) Replay(ctx context.Context, original RequestLog, sessionID string, replay ReplayRequest) ([]RequestLog, error) {
+	if original.Tunneled || (original.Kind != "" && original.Kind != KindComposed) {
+		return nil, errors.New("only HTTP requests can be replayed")
+	}
+	if replay.Body == nil && original.RequestBodyTruncated {
+		return nil, errors.New("the captured request body is truncated, send a body to replay it")
+	}
+
+	method := original.Method
+	if replay.Method != "" {
+		method = strings.ToUpper(replay.Method)
+	}
+	target := original.URL
+	if replay.URL != "" {
+		target = replay.URL
+	}
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q", target)
+	}
+
+	header := make(http.Header, len(original.RequestHeaders))
+	for k, v := range original.RequestHeaders {
+		header.Set(k, v)
+	}
+	// The captured body is stored decoded
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+	header.Del("Host")
+	for k, v := range replay.Headers {
+		if v == nil {
+			header.Del(k)
+		} else {
+			header.Set(k, *v)
+		}
+	}
+	// An Upgrade would need a real client connection to hijack
+	removeHopHeaders(header)
+
+	body := original.RawRequestBody
+	if replay.Body != nil {
+		body = []byte(*replay.Body)
+		if replay.BodyBase64 {
+			if body, err = base64.StdEncoding.DecodeString(*replay.Body); err != nil {
+				return nil, fmt.Errorf("invalid base64 body: %v", err)
+			}
+		}
+	}
+
+	repeat := min(max(replay.Repeat, 1), maxReplayRepeat)
+	concurrency := min(max(replay.Concurrency, 1), maxReplayConcurrency, repeat)
+
+	results := make([]RequestLog, repeat)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = p.replayOnce(ctx, original, sessionID, method, u, header, body)
+			}
+		}()
+	}
+	started := 0
+dispatch:
+	for ; started < repeat; started++ {
+		select {
+		case jobs <- started:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return results[:started], ctx.Err()
+}
+
+func (p *ProxyServer) replayOnce(ctx context.Context, original RequestLog, sessionID, method string, u *url.URL, header http.Header, body []byte) RequestLog {
+	// u was validated by Replay
+	req, _ := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	req.Header = header.Clone()
+
+	logEntry := RequestLog{
+		Timestamp:       time.Now(),
+		Method:          method,
+		URL:             u.String(),
+		Protocol:        strings.ToUpper(u.Scheme),
+		RequestHeaders:  make(map[string]string),
+		ResponseHeaders: make(map[string]string),
+		UserAgent:       header.Get("User-Agent"),
+		DeviceInfo:      original.DeviceInfo,
+		IsSimulator:     original.IsSimulator,
+		AppIdentifier:   original.AppIdentifier,
+		ReplayOf:        original.ID,
+		ReplayOfSession: sessionID,
+	}
+	return p.forward(&discardResponseWriter{header: make(http.Header)}, req, u.Host, logEntry)
+}
+
+// discardResponseWriter stands in for the client of requests the proxy sends
+// on its own: the response only ends up in the log.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header            { return w.header }
+func (w *discardResponseWriter) Write(data []byte) (int, error) { return len(data), nil }
+func (w *discardResponseWriter) WriteHeader(int)                {}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// Errors returned by a LogStore for unknown IDs.
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrLogNotFound     = errors.New("log not found")
+)
+
+// EventSessionChanged is sent over /ws when recording moves to a new session.
+const EventSessionChanged = "session_changed"
+
+const (
+	persistQueueSize = 1024
+	persistBatchSize = 256
+)
+
+// Session is a named capture. Every proxy run records into a new one.
+type Session struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	LogCount  int       `json:"log_count"`
+	// Size is the space taken by the session logs, bodies included
+	Size int64 `json:"size"`
+}
+
+// LogStore persists captured traffic grouped in sessions. Implementations
+// must be safe for concurrent use.
+type LogStore interface {
+	CreateSession(name string) (Session, error)
+	ListSessions() ([]Session, error)
+	GetSession(id string) (Session, error)
+	RenameSession(id, name string) error
+	DeleteSession(id string) error
+
+	// SaveLogs adds finished entries to a session in one go
+	SaveLogs(sessionID string, entries []RequestLog) error
+	// QueryLogs pages through the logs of a session like ProxyServer.QueryLogs
+	QueryLogs(sessionID string, query LogQuery) ([]RequestLog, uint64, error)
+	// GetLog returns a single entry with its bodies
+	GetLog(sessionID string, id uint64) (RequestLog, error)
+}
+
+// Store returns the configured log store, nil when logs are only kept in memory.
+func (p *ProxyServer) Store() LogStore {
+	return p.config.Store
+}
+
+// CurrentSession returns the ID of the session new logs are recorded in.
+func (p *ProxyServer) CurrentSession() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.sessionID
+}
+
+// StartSession starts recording into a new session. The in-memory log, which
+// mirrors the current session, is cleared.
+func (p *ProxyServer) StartSession(name string) (Session, error) {
+	if p.config.Store == nil {
+		return Session{}, errors.New("no session store configured")
+	}
+	if name == "" {
+		name = "Capture " + time.Now().Format("2006-01-02 15:04:05")
+	}
+	session, err := p.config.Store.CreateSession(name)
+	if err != nil {
+		return Session{}, err
+	}
+
+	p.mu.Lock()
+	p.sessionID = session.ID
+	p.logs = nil
+	p.mu.Unlock()
+
+	p.notifyEvent(Event{Type: EventSessionChanged, Data: session})
+	return session, nil
+}
+
+type pendingLog struct {
+	sessionID string
+	entry     RequestLog
+}
+
+// persistLog queues a finished entry for the store. It only blocks when the
+// writer is that far behind.
+func (p *ProxyServer) persistLog(entry RequestLog) {
+	p.mu.RLock()
+	sessionID := p.sessionID
+	p.mu.RUnlock()
+	if p.config.Store == nil || sessionID == "" {
+		return
+	}
+
+	p.persistMu.RLock()
+	defer p.persistMu.RUnlock()
+	if p.persistClosed {
+		return
+	}
+	p.persistQueue <- pendingLog{sessionID: sessionID, entry: entry}
+}
+
+// writeLogs persists the queued logs. Whatever piled up while the previous
+// batch was written goes in the next one, in a single transaction.
+func (p *ProxyServer) writeLogs() {
+	defer close(p.persistDone)
+	for pending := range p.persistQueue {
+		batch := []pendingLog{pending}
+	drain:
+		for len(batch) < persistBatchSize {
+			select {
+			case pending, ok := <-p.persistQueue:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, pending)
+			default:
+				break drain
+			}
+		}
+		p.saveBatch(batch)
+	}
+}
+
+// saveBatch writes a batch, split only where the session changes.
+func (p *ProxyServer) saveBatch(batch []pendingLog) {
+	for len(batch) > 0 {
+		sessionID := batch[0].sessionID
+		var entries []RequestLog
+		for len(batch) > 0 && batch[0].sessionID == sessionID {
+			entries = append(entries, batch[0].entry)
+			batch = batch[1:]
+		}
+		if err := p.config.Store.SaveLogs(sessionID, entries); err != nil {
+			log.Printf("Failed to save %d logs: %v", len(entries), err)
+		}
+	}
+}
+
+// Close writes out the logs still waiting for the store. Logs finished
+// afterwards are only kept in memory.
+func (p *ProxyServer) Close() {
+	if p.config.Store == nil {
+		return
+	}
+	p.persistMu.Lock()
+	if !p.persistClosed {
+		p.persistClosed = true
+		close(p.persistQueue)
+	}
+	p.persistMu.Unlock()
+	<-p.persistDone
+}
@@ -0,0 +1,291 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Snippet formats for RenderSnippet.
+const (
+	SnippetCurl   = "curl"
+	SnippetHTTPie = "httpie"
+	SnippetGo     = "go"
+	SnippetSwift  = "swift"
+	SnippetFetch  = "fetch"
+)
+
+// authHeaders carry credentials and are left out with SnippetOptions.StripAuth.
+var authHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"X-Api-Key",
+	"X-Auth-Token",
+}
+
+type SnippetOptions struct {
+	StripAuth bool
+}
+
+// snippetRequest is the part of a log entry the snippets are built from.
+type snippetRequest struct {
+	method  string
+	url     string
+	headers [][2]string
+	body    []byte
+	// compressed is set when the client asked for a compressed response
+	compressed bool
+}
+
+// RenderSnippet turns the request of a log entry into code that sends it again.
+// Like a replay, it is refused when the captured request body is truncated.
+func RenderSnippet(entry RequestLog, format string, opts SnippetOptions) (string, error) {
+	if entry.RequestBodyTruncated {
+		return "", errors.New("the captured request body is truncated, a snippet would send only part of it")
+	}
+	req := newSnippetRequest(entry, opts)
+	switch format {
+	case SnippetCurl:
+		return curlSnippet(req), nil
+	case SnippetHTTPie:
+		return httpieSnippet(req), nil
+	case SnippetGo:
+		return goSnippet(req), nil
+	case SnippetSwift:
+		return swiftSnippet(req), nil
+	case SnippetFetch:
+		return fetchSnippet(req), nil
+	}
+	return "", fmt.Errorf("unknown snippet format %q (curl, httpie, go, swift or fetch)", format)
+}
+
+func newSnippetRequest(entry RequestLog, opts SnippetOptions) snippetRequest {
+	header := make(http.Header, len(entry.RequestHeaders))
+	for k, v := range entry.RequestHeaders {
+		header.Set(k, v)
+	}
+	removeHopHeaders(header)
+	// Set by the HTTP client from the URL and the body
+	header.Del("Host")
+	header.Del("Content-Length")
+	if opts.StripAuth {
+		for _, h := range authHeaders {
+			header.Del(h)
+		}
+	}
+
+	req := snippetRequest{method: entry.Method, url: entry.URL, body: entry.RawRequestBody}
+	// The captured body is already decoded, sending the old coding would lie
+	header.Del("Content-Encoding")
+	if header.Get("Accept-Encoding") != "" {
+		req.compressed = true
+	}
+	for k := range header {
+		req.headers = append(req.headers, [2]string{k, header.Get(k)})
+	}
+	sort.Slice(req.headers, func(i, j int) bool { return req.headers[i][0] < req.headers[j][0] })
+	return req
+}
+
+func (r snippetRequest) binaryBody() bool {
+	return !utf8.Valid(r.body)
+}
+
+func curlSnippet(r snippetRequest) string {
+	var b strings.Builder
+	if len(r.body) > 0 && r.binaryBody() {
+		fmt.Fprintf(&b, "echo %s | base64 --decode | ", shellQuote(base64.StdEncoding.EncodeToString(r.body)))
+	}
+	b.WriteString("curl")
+	// curl sends GET, or POST when there is a body: other methods need -X, and
+	// HEAD needs -I since with -X curl would wait for a response body
+	inferred := http.MethodGet
+	if len(r.body) > 0 {
+		inferred = http.MethodPost
+	}
+	switch {
+	case r.method == http.MethodHead && len(r.body) == 0:
+		b.WriteString(" -I")
+	case r.method != inferred:
+		fmt.Fprintf(&b, " -X %s", shellQuote(r.method))
+	}
+	fmt.Fprintf(&b, " %s", shellQuote(r.url))
+	for _, h := range r.headers {
+		if r.compressed && h[0] == "Accept-Encoding" {
+			continue
+		}
+		if h[1] == "" {
+			// curl drops "Name:" headers, "Name;" sends them empty
+			fmt.Fprintf(&b, " \\\n  -H %s", shellQuote(h[0]+";"))
+			continue
+		}
+		fmt.Fprintf(&b, " \\\n  -H %s", shellQuote(h[0]+": "+h[1]))
+	}
+	if r.compressed {
+		b.WriteString(" \\\n  --compressed")
+	}
+	switch {
+	case len(r.body) == 0:
+	case r.binaryBody():
+		b.WriteString(" \\\n  --data-binary @-")
+	default:
+		fmt.Fprintf(&b, " \\\n  --data-raw %s", shellQuote(string(r.body)))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+func httpieSnippet(r snippetRequest) string {
+	var b strings.Builder
+	if len(r.body) > 0 && r.binaryBody() {
+		fmt.Fprintf(&b, "echo %s | base64 --decode | ", shellQuote(base64.StdEncoding.EncodeToString(r.body)))
+	}
+	b.WriteString("http")
+	if len(r.body) > 0 && !r.binaryBody() {
+		fmt.Fprintf(&b, " --raw %s", shellQuote(string(r.body)))
+	}
+	fmt.Fprintf(&b, " %s %s", shellQuote(r.method), shellQuote(r.url))
+	for _, h := range r.headers {
+		if h[1] == "" {
+			// Same as curl: "Name:" would remove the header
+			fmt.Fprintf(&b, " \\\n  %s", shellQuote(h[0]+";"))
+			continue
+		}
+		fmt.Fprintf(&b, " \\\n  %s", shellQuote(h[0]+":"+h[1]))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+func goSnippet(r snippetRequest) string {
+	var b strings.Builder
+	b.WriteString("package main\n\nimport (\n\t\"fmt\"\n\t\"io\"\n\t\"net/http\"\n")
+	if len(r.body) > 0 {
+		b.WriteString("\t\"strings\"\n")
+	}
+	b.WriteString(")\n\nfunc main() {\n")
+	body := "nil"
+	if len(r.body) > 0 {
+		// strconv.Quote escapes invalid UTF-8 as \x sequences
+		fmt.Fprintf(&b, "\tbody := strings.NewReader(%s)\n", strconv.Quote(string(r.body)))
+		body = "body"
+	}
+	fmt.Fprintf(&b, "\treq, err := http.NewRequest(%s, %s, %s)\n", strconv.Quote(r.method), strconv.Quote(r.url), body)
+	b.WriteString("\tif err != nil {\n\t\tpanic(err)\n\t}\n")
+	for _, h := range r.headers {
+		fmt.Fprintf(&b, "\treq.Header.Set(%s, %s)\n", strconv.Quote(h[0]), strconv.Quote(h[1]))
+	}
+	b.WriteString("\n\tresp, err := http.DefaultClient.Do(req)\n")
+	b.WriteString("\tif err != nil {\n\t\tpanic(err)\n\t}\n")
+	b.WriteString("\tdefer resp.Body.Close()\n\n")
+	b.WriteString("\tdata, err := io.ReadAll(resp.Body)\n")
+	b.WriteString("\tif err != nil {\n\t\tpanic(err)\n\t}\n")
+	b.WriteString("\tfmt.Println(resp.Status)\n\tfmt.Println(string(data))\n}\n")
+	return b.String()
+}
+
+func swiftSnippet(r snippetRequest) string {
+	var b strings.Builder
+	b.WriteString("import Foundation\n\n")
+	fmt.Fprintf(&b, "var request = URLRequest(url: URL(string: %s)!)\n", swiftQuote(r.url))
+	fmt.Fprintf(&b, "request.httpMethod = %s\n", swiftQuote(r.method))
+	for _, h := range r.headers {
+		fmt.Fprintf(&b, "request.setValue(%s, forHTTPHeaderField: %s)\n", swiftQuote(h[1]), swiftQuote(h[0]))
+	}
+	switch {
+	case len(r.body) == 0:
+	case r.binaryBody():
+		fmt.Fprintf(&b, "request.httpBody = Data(base64Encoded: %s)!\n", swiftQuote(base64.StdEncoding.EncodeToString(r.body)))
+	default:
+		fmt.Fprintf(&b, "request.httpBody = Data(%s.utf8)\n", swiftQuote(string(r.body)))
+	}
+	b.WriteString(`
+let task = URLSession.shared.dataTask(with: request) { data, response, error in
+    if let error = error {
+        print(error)
+        return
+    }
+    if let response = response as? HTTPURLResponse {
+        print(response.statusCode)
+    }
+    if let data = data, let body = String(data: data, encoding: .utf8) {
+        print(body)
+    }
+}
+task.resume()
+`)
+	return b.String()
+}
+
+func fetchSnippet(r snippetRequest) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "const response = await fetch(%s, {\n", jsQuote(r.url))
+	fmt.Fprintf(&b, "  method: %s,\n", jsQuote(r.method))
+	b.WriteString("  headers: {")
+	for i, h := range r.headers {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, "\n    %s: %s", jsQuote(h[0]), jsQuote(h[1]))
+	}
+	if len(r.headers) > 0 {
+		b.WriteString("\n  ")
+	}
+	b.WriteString("}")
+	switch {
+	case len(r.body) == 0:
+	case r.binaryBody():
+		fmt.Fprintf(&b, ",\n  body: Uint8Array.from(atob(%s), c => c.charCodeAt(0))", jsQuote(base64.StdEncoding.EncodeToString(r.body)))
+	default:
+		fmt.Fprintf(&b, ",\n  body: %s", jsQuote(string(r.body)))
+	}
+	b.WriteString("\n});\n\nconsole.log(response.status);\nconsole.log(await response.text());\n")
+	return b.String()
+}
+
+// shellQuote wraps s in single quotes, which leave everything literal except
+// the quote itself.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// jsQuote relies on JSON strings being valid JavaScript literals. The Go
+// encoder also escapes U+2028 and U+2029, which JavaScript did not allow.
+func jsQuote(s string) string {
+	var b strings.Builder
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func swiftQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\u{%x}`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
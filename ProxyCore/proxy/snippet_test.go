@@ -0,0 +1,31 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCurlSnippetMethod(t *testing.T) {
+	tests := []struct {
+		method string
+		body   string
+		want   string
+	}{
+		{method: "GET", want: "curl 'https://example.com/'"},
+		{method: "GET", body: "q=1", want: "curl -X 'GET' 'https://example.com/'"},
+		{method: "POST", body: "q=1", want: "curl 'https://example.com/'"},
+		{method: "POST", want: "curl -X 'POST' 'https://example.com/'"},
+		{method: "PUT", body: "q=1", want: "curl -X 'PUT' 'https://example.com/'"},
+		{method: "DELETE", want: "curl -X 'DELETE' 'https://example.com/'"},
+		{method: "HEAD", want: "curl -I 'https://example.com/'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.body, func(t *testing.T) {
+			snippet := curlSnippet(snippetRequest{method: tt.method, url: "https://example.com/", body: []byte(tt.body)})
+			if got, _, _ := strings.Cut(snippet, " \\\n"); strings.TrimSuffix(got, "\n") != tt.want {
+				t.Fatalf("curlSnippet() = %q, want it to start with %q", snippet, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EventSSE is sent over /ws for every Server-Sent Event relayed to a client,
+// as it arrives, since the request log is only added once the stream ends.
+const EventSSE = "sse_event"
+
+const (
+	// defaultMaxCaptureSize bounds how much of a body is kept for the log
+	defaultMaxCaptureSize = 4 << 20
+	// streamingThreshold is the Content-Length above which a response is
+	// relayed as a stream even if its length is known
+	streamingThreshold = 1 << 20
+	// maxSSEEvents bounds the events kept on a single RequestLog
+	maxSSEEvents = 1000
+	// maxSSELine bounds a single line of an event stream
+	maxSSELine = 1 << 20
+)
+
+// SSEEvent is a single event parsed from a text/event-stream response.
+type SSEEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	ID        string    `json:"id,omitempty"`
+	Event     string    `json:"event,omitempty"`
+	Data      string    `json:"data"`
+	Retry     int       `json:"retry,omitempty"`
+}
+
+// sseMessage is the payload of an EventSSE notification.
+type sseMessage struct {
+	ID    uint64   `json:"id"`
+	URL   string   `json:"url"`
+	Event SSEEvent `json:"event"`
+}
+
+func isEventStream(header http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// isStreamingResponse reports whether the body should reach the client as it
+// arrives instead of once complete: event streams, bodies of unknown length
+// (chunked or close-delimited, as in long-polls) and large downloads.
+func isStreamingResponse(resp *http.Response) bool {
+	return isEventStream(resp.Header) || resp.ContentLength < 0 || resp.ContentLength > streamingThreshold
+}
+
+func (p *ProxyServer) maxCaptureSize() int {
+	if p.config.MaxCaptureSize > 0 {
+		return p.config.MaxCaptureSize
+	}
+	return defaultMaxCaptureSize
+}
+
+// relayBody copies body to the client, flushing after every read when flush is
+// set, while keeping a bounded copy in capture. Every chunk is also handed to
+// observe, if not nil. It stops at the first error on either side.
+func relayBody(w http.ResponseWriter, body io.Reader, capture io.Writer, flush bool, observe func([]byte)) error {
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			capture.Write(buf[:n])
+			if observe != nil {
+				observe(buf[:n])
+			}
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if flush && flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// boundedBuffer keeps the first limit bytes written to it and drops the rest.
+type boundedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *boundedBuffer) Write(data []byte) (int, error) {
+	if room := b.limit - b.Len(); room < len(data) {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(data[:room])
+		}
+		return len(data), nil
+	}
+	return b.Buffer.Write(data)
+}
+
+// sseParser splits a text/event-stream into events as the bytes come in,
+// following the WHATWG event stream interpretation rules.
+type sseParser struct {
+	pending []byte
+	event   SSEEvent
+	data    []string
+	hasData bool
+	emit    func(SSEEvent)
+	// skipLF drops the \n of a \r\n pair split across two writes
+	skipLF bool
+}
+
+func newSSEParser(emit func(SSEEvent)) *sseParser {
+	return &sseParser{emit: emit}
+}
+
+func (s *sseParser) Write(data []byte) {
+	if s.skipLF && len(data) > 0 && data[0] == '\n' {
+		data = data[1:]
+	}
+	s.skipLF = false
+	s.pending = append(s.pending, data...)
+	if len(s.pending) > maxSSELine {
+		// Not an event stream after all, don't keep it all in memory
+		s.pending = s.pending[:0]
+	}
+
+	for {
+		i := bytes.IndexAny(s.pending, "\r\n")
+		if i < 0 {
+			return
+		}
+		line := string(s.pending[:i])
+		next := i + 1
+		if s.pending[i] == '\r' {
+			if next == len(s.pending) {
+				s.skipLF = true
+			} else if s.pending[next] == '\n' {
+				next++
+			}
+		}
+		s.pending = s.pending[next:]
+		s.processLine(line)
+	}
+}
+
+func (s *sseParser) processLine(line string) {
+	if line == "" {
+		s.dispatch()
+		return
+	}
+	if strings.HasPrefix(line, ":") {
+		return // comment
+	}
+
+	field, value, _ := strings.Cut(line, ":")
+	value = strings.TrimPrefix(value, " ")
+	switch field {
+	case "event":
+		s.event.Event = value
+	case "data":
+		s.data = append(s.data, value)
+		s.hasData = true
+	case "id":
+		if !strings.ContainsRune(value, 0) {
+			s.event.ID = value
+		}
+	case "retry":
+		if retry, err := strconv.Atoi(value); err == nil {
+			s.event.Retry = retry
+		}
+	}
+}
+
+func (s *sseParser) dispatch() {
+	if s.hasData {
+		event := s.event
+		event.Data = strings.Join(s.data, "\n")
+		event.Timestamp = time.Now()
+		s.emit(event)
+	}
+	// The last event ID carries over to the following events
+	s.event = SSEEvent{ID: s.event.ID}
+	s.data = nil
+	s.hasData = false
+}
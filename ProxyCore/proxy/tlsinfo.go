@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TLSInfo describes one side of an intercepted TLS connection. Offered values
+// and fingerprints are only known for the client side.
+type TLSInfo struct {
+	ServerName      string   `json:"server_name,omitempty"`
+	OfferedVersions []string `json:"offered_versions,omitempty"`
+	Version         string   `json:"version,omitempty"`
+	CipherSuite     string   `json:"cipher_suite,omitempty"`
+	OfferedALPN     []string `json:"offered_alpn,omitempty"`
+	ALPN            string   `json:"alpn,omitempty"`
+	JA3             string   `json:"ja3,omitempty"`
+	JA3Hash         string   `json:"ja3_hash,omitempty"`
+	JA4             string   `json:"ja4,omitempty"`
+}
+
+// clientHelloInfo extracts what the client offered, before the handshake
+// completes.
+func clientHelloInfo(hello *tls.ClientHelloInfo) *TLSInfo {
+	info := &TLSInfo{
+		ServerName:  hello.ServerName,
+		OfferedALPN: hello.SupportedProtos,
+	}
+	for _, v := range hello.SupportedVersions {
+		if !isGREASE(v) {
+			info.OfferedVersions = append(info.OfferedVersions, tls.VersionName(v))
+		}
+	}
+	info.JA3 = ja3String(hello)
+	sum := md5.Sum([]byte(info.JA3))
+	info.JA3Hash = hex.EncodeToString(sum[:])
+	info.JA4 = ja4String(hello)
+	return info
+}
+
+// applyConnectionState fills in the negotiated parameters.
+func (info *TLSInfo) applyConnectionState(state tls.ConnectionState) {
+	if info.ServerName == "" {
+		info.ServerName = state.ServerName
+	}
+	info.Version = tls.VersionName(state.Version)
+	info.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	info.ALPN = state.NegotiatedProtocol
+}
+
+// upstreamTLSInfo describes the connection the proxy opened to the origin.
+func upstreamTLSInfo(state *tls.ConnectionState) *TLSInfo {
+	if state == nil {
+		return nil
+	}
+	info := &TLSInfo{}
+	info.applyConnectionState(*state)
+	return info
+}
+
+// GREASE values (RFC 8701) are random placeholders and must be ignored by
+// fingerprints.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// legacyVersion reconstructs the ClientHello legacy_version field: TLS 1.3
+// clients always send TLS 1.2 there.
+func legacyVersion(hello *tls.ClientHelloInfo) uint16 {
+	var max uint16
+	for _, v := range hello.SupportedVersions {
+		if !isGREASE(v) && v > max {
+			max = v
+		}
+	}
+	if max > tls.VersionTLS12 {
+		return tls.VersionTLS12
+	}
+	return max
+}
+
+func joinUint16(values []uint16, sep string, format func(uint16) string) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		if !isGREASE(v) {
+			parts = append(parts, format(v))
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+func decimal(v uint16) string {
+	return strconv.Itoa(int(v))
+}
+
+func hex4(v uint16) string {
+	return fmt.Sprintf("%04x", v)
+}
+
+// ja3String builds "SSLVersion,Ciphers,Extensions,Curves,PointFormats".
+func ja3String(hello *tls.ClientHelloInfo) string {
+	curves := make([]uint16, 0, len(hello.SupportedCurves))
+	for _, c := range hello.SupportedCurves {
+		curves = append(curves, uint16(c))
+	}
+	points := make([]uint16, 0, len(hello.SupportedPoints))
+	for _, p := range hello.SupportedPoints {
+		points = append(points, uint16(p))
+	}
+	return strings.Join([]string{
+		decimal(legacyVersion(hello)),
+		joinUint16(hello.CipherSuites, "-", decimal),
+		joinUint16(hello.Extensions, "-", decimal),
+		joinUint16(curves, "-", decimal),
+		joinUint16(points, "-", decimal),
+	}, ",")
+}
+
+// ja4String builds the JA4 fingerprint (FoxIO spec) for a TCP ClientHello.
+func ja4String(hello *tls.ClientHelloInfo) string {
+	var version uint16
+	for _, v := range hello.SupportedVersions {
+		if !isGREASE(v) && v > version {
+			version = v
+		}
+	}
+	versionCode := map[uint16]string{
+		tls.VersionTLS13: "13",
+		tls.VersionTLS12: "12",
+		tls.VersionTLS11: "11",
+		tls.VersionTLS10: "10",
+	}[version]
+	if versionCode == "" {
+		versionCode = "00"
+	}
+
+	sni := "i"
+	if hello.ServerName != "" {
+		sni = "d"
+	}
+
+	var ciphers, extensions, sortedExtensions []uint16
+	for _, c := range hello.CipherSuites {
+		if !isGREASE(c) {
+			ciphers = append(ciphers, c)
+		}
+	}
+	for _, e := range hello.Extensions {
+		if isGREASE(e) {
+			continue
+		}
+		extensions = append(extensions, e)
+		// SNI and ALPN are left out of the hashed extension list
+		if e != 0x0000 && e != 0x0010 {
+			sortedExtensions = append(sortedExtensions, e)
+		}
+	}
+
+	alpn := "00"
+	if len(hello.SupportedProtos) > 0 && hello.SupportedProtos[0] != "" {
+		first := hello.SupportedProtos[0]
+		alpn = string(first[0]) + string(first[len(first)-1])
+	}
+
+	prefix := fmt.Sprintf("t%s%s%02d%02d%s", versionCode, sni, min(len(ciphers), 99), min(len(extensions), 99), alpn)
+
+	sort.Slice(ciphers, func(i, j int) bool { return ciphers[i] < ciphers[j] })
+	sort.Slice(sortedExtensions, func(i, j int) bool { return sortedExtensions[i] < sortedExtensions[j] })
+
+	schemes := make([]uint16, 0, len(hello.SignatureSchemes))
+	for _, s := range hello.SignatureSchemes {
+		schemes = append(schemes, uint16(s))
+	}
+	extensionPart := joinUint16(sortedExtensions, ",", hex4)
+	if len(schemes) > 0 {
+		extensionPart += "_" + joinUint16(schemes, ",", hex4)
+	}
+
+	return prefix + "_" + truncatedHash(joinUint16(ciphers, ",", hex4)) + "_" + truncatedHash(extensionPart)
+}
+
+func truncatedHash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// shouldDecrypt reports whether a CONNECT to host should be intercepted.
+// Traffic is decrypted by default; hosts on the pass-through list and apps
+// registered with DecryptTraffic set to false are tunneled untouched so
+// certificate pinning keeps working.
+func (p *ProxyServer) shouldDecrypt(host, appIdentifier string) bool {
+	if p.passThrough.Contains(host) {
+		return false
+	}
+	if app, ok := p.appsManager.FindApp(appIdentifier, host); ok {
+		return app.DecryptTraffic
+	}
+	return true
+}
+
+// handleTunnel relays raw bytes between the client and the origin without
+// terminating TLS. The whole connection is logged as a single CONNECT entry.
+func (p *ProxyServer) handleTunnel(clientConn net.Conn, host string, logEntry RequestLog) {
+	log.Printf("[TUNNEL] %s -> %s", logEntry.ClientIP, host)
+
+	logEntry.Tunneled = true
+	logEntry.Protocol = "TUNNEL"
+	p.startLog(&logEntry)
+
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "443")
+	}
+
+	upstreamConn, err := net.DialTimeout("tcp", host, 10*time.Second)
+	if err != nil {
+		log.Printf("[TUNNEL] Error connecting to %s: %v", host, err)
+		clientConn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+		logEntry.StatusCode = 502
+		logEntry.Completed = time.Now()
+		logEntry.ResponseTime = logEntry.Completed.Sub(logEntry.Timestamp)
+		p.addLog(logEntry)
+		return
+	}
+	defer upstreamConn.Close()
+
+	if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		logEntry.Error = err.Error()
+		logEntry.Completed = time.Now()
+		logEntry.ResponseTime = logEntry.Completed.Sub(logEntry.Timestamp)
+		p.addLog(logEntry)
+		return
+	}
+	logEntry.StatusCode = 200
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		logEntry.BytesOut, _ = io.Copy(upstreamConn, clientConn)
+		closeWrite(upstreamConn)
+	}()
+	go func() {
+		defer wg.Done()
+		logEntry.BytesIn, _ = io.Copy(clientConn, upstreamConn)
+		closeWrite(clientConn)
+	}()
+	wg.Wait()
+
+	logEntry.Completed = time.Now()
+	logEntry.ResponseTime = logEntry.Completed.Sub(logEntry.Timestamp)
+	p.addLog(logEntry)
+}
+
+// closeWrite half-closes the connection when supported so the other side sees
+// EOF while the opposite direction keeps flowing.
+func closeWrite(conn net.Conn) {
+	if buffered, ok := conn.(*bufferedConn); ok {
+		conn = buffered.Conn
+	}
+	if tcpConn, ok := conn.(interface{ CloseWrite() error }); ok {
+		tcpConn.CloseWrite()
+		return
+	}
+	conn.Close()
+}
@@ -0,0 +1,382 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// KindWebSocket marks the RequestLog of an upgraded WebSocket connection. The
+// entry is added when the connection closes; frames are streamed separately.
+const KindWebSocket = "websocket"
+
+// Frame directions, from the point of view of the client app.
+const (
+	FrameOutgoing = "outgoing" // client -> server
+	FrameIncoming = "incoming" // server -> client
+)
+
+// maxFramePayload bounds how much of each frame payload is kept for the log.
+const maxFramePayload = 64 * 1024
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// WebSocketFrame is a single frame relayed on an intercepted WebSocket.
+type WebSocketFrame struct {
+	ConnectionID string    `json:"connection_id"`
+	URL          string    `json:"url"`
+	Timestamp    time.Time `json:"timestamp"`
+	Direction    string    `json:"direction"`
+	Opcode       int       `json:"opcode"`
+	Type         string    `json:"type"`
+	Fin          bool      `json:"fin"`
+	Size         int64     `json:"size"`
+	// Payload is the unmasked payload, base64 encoded when it is not valid
+	// UTF-8 text. For close frames it holds the reason and CloseCode the code.
+	Payload       string `json:"payload,omitempty"`
+	PayloadBase64 bool   `json:"payload_base64,omitempty"`
+	Truncated     bool   `json:"truncated,omitempty"`
+	CloseCode     int    `json:"close_code,omitempty"`
+}
+
+func frameType(opcode byte) string {
+	switch opcode {
+	case opContinuation:
+		return "continuation"
+	case opText:
+		return "text"
+	case opBinary:
+		return "binary"
+	case opClose:
+		return "close"
+	case opPing:
+		return "ping"
+	case opPong:
+		return "pong"
+	}
+	return fmt.Sprintf("reserved-%x", opcode)
+}
+
+func isWebSocketUpgrade(req *http.Request) bool {
+	return headerContainsToken(req.Header, "Connection", "upgrade") &&
+		strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+}
+
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleWebSocket forwards an upgrade request to the origin and, once both
+// sides switched protocols, splices the two connections parsing every frame
+// that goes through. Other answers from the origin are relayed as usual.
+func (p *ProxyServer) handleWebSocket(w http.ResponseWriter, req *http.Request, logEntry RequestLog) {
+	logEntry.Kind = KindWebSocket
+	// fail completes the entry of a connection that never got relayed
+	fail := func(statusCode int, err error) {
+		logEntry.StatusCode = statusCode
+		logEntry.Error = err.Error()
+		logEntry.Completed = time.Now()
+		logEntry.ResponseTime = logEntry.Completed.Sub(logEntry.Timestamp)
+		p.addLog(logEntry)
+	}
+
+	upstreamConn, err := p.dialWebSocket(req.URL, &logEntry)
+	if err != nil {
+		log.Printf("[WS] Error connecting to %s: %v", req.URL.Host, err)
+		fail(http.StatusBadGateway, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer upstreamConn.Close()
+
+	outReq := req.Clone(req.Context())
+	outReq.RequestURI = ""
+	outReq.Header.Del("Proxy-Connection")
+	outReq.Header.Del("Proxy-Authorization")
+	// Without permessage-deflate the payloads stay readable in the frame log
+	outReq.Header.Del("Sec-WebSocket-Extensions")
+
+	if err := outReq.Write(upstreamConn); err != nil {
+		log.Printf("[WS] Error sending upgrade to %s: %v", req.URL.Host, err)
+		fail(http.StatusBadGateway, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	upstreamReader := bufio.NewReader(upstreamConn)
+	resp, err := http.ReadResponse(upstreamReader, outReq)
+	if err != nil {
+		log.Printf("[WS] Error reading upgrade response from %s: %v", req.URL.Host, err)
+		fail(http.StatusBadGateway, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	logEntry.UpstreamProtocol = resp.Proto
+	logEntry.StatusCode = resp.StatusCode
+	for k, v := range resp.Header {
+		logEntry.ResponseHeaders[k] = strings.Join(v, ", ")
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// The origin refused the upgrade: relay its answer like any response
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		capture := newBodyCapture(p.maxCaptureSize())
+		io.Copy(io.MultiWriter(w, capture), resp.Body)
+		logEntry.setResponseBody(capture.result(resp.Header.Get("Content-Encoding"), p.maxCaptureSize()), resp.Header.Get("Content-Type"))
+		logEntry.Completed = time.Now()
+		logEntry.ResponseTime = logEntry.Completed.Sub(logEntry.Timestamp)
+		p.addLog(logEntry)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		fail(http.StatusInternalServerError, errors.New("hijacking not supported"))
+		http.Error(w, "Hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		log.Printf("[WS] Hijack failed: %v", err)
+		fail(http.StatusInternalServerError, err)
+		return
+	}
+	defer clientConn.Close()
+
+	var handshake bytes.Buffer
+	fmt.Fprintf(&handshake, "HTTP/1.1 %s\r\n", resp.Status)
+	resp.Header.Write(&handshake)
+	handshake.WriteString("\r\n")
+	if _, err := clientConn.Write(handshake.Bytes()); err != nil {
+		// The origin did switch protocols, the client never heard of it
+		fail(resp.StatusCode, err)
+		return
+	}
+
+	logEntry.WebSocketID = newID()
+	log.Printf("[WS] %s connected (%s)", logEntry.URL, logEntry.WebSocketID)
+
+	// WebSockets have no half-close: once either side is gone tear down both
+	connectionID, connectionURL := logEntry.WebSocketID, logEntry.URL
+	var bytesOut, bytesIn int64
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		bytesOut, _ = p.relayFrames(upstreamConn, clientBuf.Reader, connectionID, connectionURL, FrameOutgoing)
+		upstreamConn.Close()
+		clientConn.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		bytesIn, _ = p.relayFrames(clientConn, upstreamReader, connectionID, connectionURL, FrameIncoming)
+		upstreamConn.Close()
+		clientConn.Close()
+	}()
+	wg.Wait()
+
+	logEntry.BytesOut, logEntry.BytesIn = bytesOut, bytesIn
+	logEntry.Completed = time.Now()
+	logEntry.ResponseTime = logEntry.Completed.Sub(logEntry.Timestamp)
+	p.addLog(logEntry)
+}
+
+// dialWebSocket opens the raw connection to the origin. Over TLS only
+// HTTP/1.1 is offered, since the upgrade mechanism does not exist in HTTP/2.
+func (p *ProxyServer) dialWebSocket(u *url.URL, logEntry *RequestLog) (net.Conn, error) {
+	addr := u.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		if u.Scheme == "https" {
+			addr = net.JoinHostPort(addr, "443")
+		} else {
+			addr = net.JoinHostPort(addr, "80")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if u.Scheme != "https" {
+		return dialer.Dial("tcp", addr)
+	}
+
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{
+		ServerName:         u.Hostname(),
+		NextProtos:         []string{"http/1.1"},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	state := conn.ConnectionState()
+	logEntry.UpstreamTLS = upstreamTLSInfo(&state)
+	if len(state.PeerCertificates) > 0 {
+		p.certManager.RecordUpstreamCertificate(u.Host, state.PeerCertificates[0])
+	}
+	return conn, nil
+}
+
+// relayFrames copies WebSocket frames from src to dst as they arrive, logging
+// each of them under connectionID. Payloads are streamed, only the first
+// maxFramePayload bytes are kept. It returns the number of bytes relayed.
+func (p *ProxyServer) relayFrames(dst io.Writer, src *bufio.Reader, connectionID, connectionURL, direction string) (int64, error) {
+	var total int64
+	var messageType byte // opcode of the message continuation frames belong to
+	header := make([]byte, 14)
+
+	for {
+		if _, err := io.ReadFull(src, header[:2]); err != nil {
+			return total, err
+		}
+		fin := header[0]&0x80 != 0
+		opcode := header[0] & 0x0f
+		masked := header[1]&0x80 != 0
+
+		n := 2
+		length := int64(header[1] & 0x7f)
+		switch length {
+		case 126:
+			if _, err := io.ReadFull(src, header[n:n+2]); err != nil {
+				return total, err
+			}
+			length = int64(binary.BigEndian.Uint16(header[n:]))
+			n += 2
+		case 127:
+			if _, err := io.ReadFull(src, header[n:n+8]); err != nil {
+				return total, err
+			}
+			length = int64(binary.BigEndian.Uint64(header[n:]) & (1<<63 - 1))
+			n += 8
+		}
+		var mask []byte
+		if masked {
+			if _, err := io.ReadFull(src, header[n:n+4]); err != nil {
+				return total, err
+			}
+			mask = append([]byte(nil), header[n:n+4]...)
+			n += 4
+		}
+
+		if _, err := dst.Write(header[:n]); err != nil {
+			return total, err
+		}
+		total += int64(n)
+
+		capture := &boundedBuffer{limit: maxFramePayload}
+		copied, err := io.CopyN(io.MultiWriter(dst, capture), src, length)
+		total += copied
+		if err != nil {
+			return total, err
+		}
+
+		payload := capture.Bytes()
+		for i := range payload {
+			if mask != nil {
+				payload[i] ^= mask[i%4]
+			}
+		}
+
+		if opcode == opText || opcode == opBinary {
+			messageType = opcode
+		}
+		frame := WebSocketFrame{
+			ConnectionID: connectionID,
+			URL:          connectionURL,
+			Timestamp:    time.Now(),
+			Direction:    direction,
+			Opcode:       int(opcode),
+			Type:         frameType(opcode),
+			Fin:          fin,
+			Size:         length,
+			Truncated:    capture.truncated,
+		}
+		if opcode == opClose && len(payload) >= 2 {
+			frame.CloseCode = int(binary.BigEndian.Uint16(payload))
+			payload = payload[2:]
+		}
+		isText := opcode == opText || opcode == opClose ||
+			(opcode == opContinuation && messageType == opText)
+		if isText && utf8.Valid(payload) {
+			frame.Payload = string(payload)
+		} else if len(payload) > 0 {
+			frame.Payload = base64.StdEncoding.EncodeToString(payload)
+			frame.PayloadBase64 = true
+		}
+		p.addFrame(frame)
+	}
+}
+
+func (p *ProxyServer) addFrame(frame WebSocketFrame) {
+	p.mu.Lock()
+	p.frames = append(p.frames, frame)
+	if len(p.frames) > 1000 { // Keep last 1000 frames
+		p.frames = p.frames[1:]
+	}
+	for ch := range p.frameClients {
+		select {
+		case ch <- frame:
+		default:
+			// Skip if channel is full
+		}
+	}
+	p.mu.Unlock()
+}
+
+// GetFrames returns the recent WebSocket frames, optionally only those of one
+// connection.
+func (p *ProxyServer) GetFrames(connectionID string) []WebSocketFrame {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	frames := []WebSocketFrame{}
+	for _, frame := range p.frames {
+		if connectionID == "" || frame.ConnectionID == connectionID {
+			frames = append(frames, frame)
+		}
+	}
+	return frames
+}
+
+func (p *ProxyServer) SubscribeFrames() chan WebSocketFrame {
+	ch := make(chan WebSocketFrame, 64)
+	p.mu.Lock()
+	p.frameClients[ch] = struct{}{}
+	p.mu.Unlock()
+	return ch
+}
+
+func (p *ProxyServer) UnsubscribeFrames(ch chan WebSocketFrame) {
+	p.mu.Lock()
+	delete(p.frameClients, ch)
+	p.mu.Unlock()
+	close(ch)
+}
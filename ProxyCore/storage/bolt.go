@@ -0,0 +1,419 @@
+// Package storage keeps captured traffic on disk so sessions survive restarts.
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"proxy_core/proxy"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	sessionsBucket = []byte("sessions")
+	logsBucket     = []byte("logs")   // one nested bucket per session, ID -> entry
+	bodiesBucket   = []byte("bodies") // one nested bucket per session, ID+part -> body
+	indexBucket    = []byte("index")  // insertion order across sessions, for retention
+	metaBucket     = []byte("meta")
+
+	totalSizeKey  = []byte("size")
+	totalCountKey = []byte("count")
+)
+
+const (
+	requestPart  = 'q'
+	responsePart = 's'
+)
+
+// Retention limits what the store keeps. Once a limit is exceeded the oldest
+// logs are dropped, whatever session they belong to. Zero disables a limit.
+type Retention struct {
+	MaxLogs int
+	MaxAge  time.Duration
+	// MaxSize is in bytes, bodies included
+	MaxSize int64
+}
+
+// BoltStore is a proxy.LogStore backed by a bbolt database file.
+type BoltStore struct {
+	db        *bolt.DB
+	retention Retention
+}
+
+func NewBoltStore(path string, retention Retention) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+
+	store := &BoltStore{db: db, retention: retention}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{sessionsBucket, logsBucket, bodiesBucket, indexBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		// Limits may have changed since the last run
+		return store.applyRetention(tx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltStore) CreateSession(name string) (proxy.Session, error) {
+	now := time.Now()
+	session := proxy.Session{ID: newSessionID(), Name: name, CreatedAt: now, UpdatedAt: now}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.Bucket(logsBucket).CreateBucket([]byte(session.ID)); err != nil {
+			return err
+		}
+		if _, err := tx.Bucket(bodiesBucket).CreateBucket([]byte(session.ID)); err != nil {
+			return err
+		}
+		return putSession(tx, session)
+	})
+	return session, err
+}
+
+// ListSessions returns every stored session, newest first.
+func (s *BoltStore) ListSessions() ([]proxy.Session, error) {
+	sessions := []proxy.Session{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).ForEach(func(_, data []byte) error {
+			var session proxy.Session
+			if err := json.Unmarshal(data, &session); err != nil {
+				return err
+			}
+			sessions = append(sessions, session)
+			return nil
+		})
+	})
+	// Newest first
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+	})
+	return sessions, err
+}
+
+func (s *BoltStore) GetSession(id string) (proxy.Session, error) {
+	var session proxy.Session
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		session, err = getSession(tx, id)
+		return err
+	})
+	return session, err
+}
+
+func (s *BoltStore) RenameSession(id, name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		session, err := getSession(tx, id)
+		if err != nil {
+			return err
+		}
+		session.Name = name
+		session.UpdatedAt = time.Now()
+		return putSession(tx, session)
+	})
+}
+
+// DeleteSession removes a session with all its logs. Its entries in the
+// retention index are dropped lazily.
+func (s *BoltStore) DeleteSession(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		session, err := getSession(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(logsBucket).DeleteBucket([]byte(id)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bodiesBucket).DeleteBucket([]byte(id)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(sessionsBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+		return addTotals(tx, -int64(session.LogCount), -session.Size)
+	})
+}
+
+// SaveLogs stores the entries in a single transaction, applying the
+// retention limits once at the end.
+func (s *BoltStore) SaveLogs(sessionID string, entries []proxy.RequestLog) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		session, err := getSession(tx, sessionID)
+		if err != nil {
+			return err
+		}
+		logs := tx.Bucket(logsBucket).Bucket([]byte(sessionID))
+		bodies := tx.Bucket(bodiesBucket).Bucket([]byte(sessionID))
+		index := tx.Bucket(indexBucket)
+
+		var total int64
+		for _, entry := range entries {
+			data, err := json.Marshal(entry.WithoutBodies())
+			if err != nil {
+				return err
+			}
+			size := int64(len(data) + len(entry.RawRequestBody) + len(entry.RawResponseBody))
+
+			if err := logs.Put(logKey(entry.ID), data); err != nil {
+				return err
+			}
+			if len(entry.RawRequestBody) > 0 {
+				if err := bodies.Put(bodyKey(entry.ID, requestPart), entry.RawRequestBody); err != nil {
+					return err
+				}
+			}
+			if len(entry.RawResponseBody) > 0 {
+				if err := bodies.Put(bodyKey(entry.ID, responsePart), entry.RawResponseBody); err != nil {
+					return err
+				}
+			}
+
+			seq, err := index.NextSequence()
+			if err != nil {
+				return err
+			}
+			ref := indexRef{Session: sessionID, ID: entry.ID, Size: size, Timestamp: entry.Timestamp}
+			refData, err := json.Marshal(ref)
+			if err != nil {
+				return err
+			}
+			if err := index.Put(logKey(seq), refData); err != nil {
+				return err
+			}
+			total += size
+		}
+
+		session.LogCount += len(entries)
+		session.Size += total
+		session.UpdatedAt = time.Now()
+		if err := putSession(tx, session); err != nil {
+			return err
+		}
+		if err := addTotals(tx, int64(len(entries)), total); err != nil {
+			return err
+		}
+		return s.applyRetention(tx)
+	})
+}
+
+func (s *BoltStore) QueryLogs(sessionID string, query proxy.LogQuery) ([]proxy.RequestLog, uint64, error) {
+	query = query.Normalized()
+	page := []proxy.RequestLog{}
+	var next uint64
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		logs := tx.Bucket(logsBucket).Bucket([]byte(sessionID))
+		if logs == nil {
+			return proxy.ErrSessionNotFound
+		}
+		bodies := tx.Bucket(bodiesBucket).Bucket([]byte(sessionID))
+
+		cursor := logs.Cursor()
+		var key, data []byte
+		step := cursor.Prev
+		switch {
+		case query.Ascending:
+			step = cursor.Next
+			key, data = cursor.Seek(logKey(query.Cursor + 1))
+		case query.Cursor != 0:
+			// Seek lands on the cursor itself or past it
+			if key, _ = cursor.Seek(logKey(query.Cursor)); key == nil {
+				key, data = cursor.Last()
+			} else {
+				key, data = cursor.Prev()
+			}
+		default:
+			key, data = cursor.Last()
+		}
+
+		for ; key != nil; key, data = step() {
+			var entry proxy.RequestLog
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return err
+			}
+			if !query.AfterCursor(entry.ID) {
+				continue
+			}
+			entry.RestoreBodies(readBodies(bodies, entry.ID))
+			if !query.Filter.Match(entry) {
+				continue
+			}
+			if len(page) == query.Limit {
+				next = page[len(page)-1].ID
+				return nil
+			}
+			page = append(page, entry)
+		}
+		return nil
+	})
+	return page, next, err
+}
+
+func (s *BoltStore) GetLog(sessionID string, id uint64) (proxy.RequestLog, error) {
+	var entry proxy.RequestLog
+	err := s.db.View(func(tx *bolt.Tx) error {
+		logs := tx.Bucket(logsBucket).Bucket([]byte(sessionID))
+		if logs == nil {
+			return proxy.ErrSessionNotFound
+		}
+		data := logs.Get(logKey(id))
+		if data == nil {
+			return proxy.ErrLogNotFound
+		}
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return err
+		}
+		entry.RestoreBodies(readBodies(tx.Bucket(bodiesBucket).Bucket([]byte(sessionID)), id))
+		return nil
+	})
+	return entry, err
+}
+
+// indexRef points from the retention index to a stored log.
+type indexRef struct {
+	Session   string    `json:"session"`
+	ID        uint64    `json:"id"`
+	Size      int64     `json:"size"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// applyRetention drops the oldest logs until the store is within its limits.
+func (s *BoltStore) applyRetention(tx *bolt.Tx) error {
+	r := s.retention
+	if r.MaxLogs <= 0 && r.MaxAge <= 0 && r.MaxSize <= 0 {
+		return nil
+	}
+
+	meta := tx.Bucket(metaBucket)
+	count, size := getCounter(meta, totalCountKey), getCounter(meta, totalSizeKey)
+	cutoff := time.Now().Add(-r.MaxAge)
+
+	cursor := tx.Bucket(indexBucket).Cursor()
+	for key, data := cursor.First(); key != nil; key, data = cursor.First() {
+		var ref indexRef
+		if err := json.Unmarshal(data, &ref); err != nil {
+			return err
+		}
+		session, err := getSession(tx, ref.Session)
+		if err == proxy.ErrSessionNotFound {
+			// Left over from a deleted session
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		overCount := r.MaxLogs > 0 && count > int64(r.MaxLogs)
+		overSize := r.MaxSize > 0 && size > r.MaxSize
+		tooOld := r.MaxAge > 0 && ref.Timestamp.Before(cutoff)
+		if !overCount && !overSize && !tooOld {
+			break
+		}
+
+		tx.Bucket(logsBucket).Bucket([]byte(ref.Session)).Delete(logKey(ref.ID))
+		bodies := tx.Bucket(bodiesBucket).Bucket([]byte(ref.Session))
+		bodies.Delete(bodyKey(ref.ID, requestPart))
+		bodies.Delete(bodyKey(ref.ID, responsePart))
+		if err := cursor.Delete(); err != nil {
+			return err
+		}
+
+		session.LogCount--
+		session.Size -= ref.Size
+		if err := putSession(tx, session); err != nil {
+			return err
+		}
+		count--
+		size -= ref.Size
+	}
+
+	if err := putCounter(meta, totalCountKey, count); err != nil {
+		return err
+	}
+	return putCounter(meta, totalSizeKey, size)
+}
+
+func getSession(tx *bolt.Tx, id string) (proxy.Session, error) {
+	var session proxy.Session
+	data := tx.Bucket(sessionsBucket).Get([]byte(id))
+	if data == nil {
+		return session, proxy.ErrSessionNotFound
+	}
+	err := json.Unmarshal(data, &session)
+	return session, err
+}
+
+func putSession(tx *bolt.Tx, session proxy.Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(sessionsBucket).Put([]byte(session.ID), data)
+}
+
+func readBodies(bodies *bolt.Bucket, id uint64) ([]byte, []byte) {
+	// Values are only valid inside the transaction
+	request := append([]byte(nil), bodies.Get(bodyKey(id, requestPart))...)
+	response := append([]byte(nil), bodies.Get(bodyKey(id, responsePart))...)
+	return request, response
+}
+
+func addTotals(tx *bolt.Tx, count, size int64) error {
+	meta := tx.Bucket(metaBucket)
+	if err := putCounter(meta, totalCountKey, getCounter(meta, totalCountKey)+count); err != nil {
+		return err
+	}
+	return putCounter(meta, totalSizeKey, getCounter(meta, totalSizeKey)+size)
+}
+
+func getCounter(bucket *bolt.Bucket, key []byte) int64 {
+	data := bucket.Get(key)
+	if len(data) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(data))
+}
+
+func putCounter(bucket *bolt.Bucket, key []byte, value int64) error {
+	if value < 0 {
+		value = 0
+	}
+	return bucket.Put(key, binary.BigEndian.AppendUint64(nil, uint64(value)))
+}
+
+// Big-endian keys keep bbolt's byte order equal to the numeric order.
+func logKey(id uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, id)
+}
+
+func bodyKey(id uint64, part byte) []byte {
+	return append(logKey(id), part)
+}
+
+func newSessionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}